@@ -0,0 +1,459 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of a mycached server.
+type Config struct {
+	Listen    ListenConfig    `yaml:"listen" json:"listen"`
+	Peers     PeersConfig     `yaml:"peers" json:"peers"`
+	TLS       TLSConfig       `yaml:"tls" json:"tls"`
+	Auth      AuthConfig      `yaml:"auth" json:"auth"`
+	Transport TransportConfig `yaml:"transport" json:"transport"`
+	Admission AdmissionConfig `yaml:"admission" json:"admission"`
+	Shutdown  ShutdownConfig  `yaml:"shutdown" json:"shutdown"`
+	Rebalance RebalanceConfig `yaml:"rebalance" json:"rebalance"`
+	// SnapshotDir, if set, is where the groups are snapshotted on shutdown and restored from on start.
+	SnapshotDir string        `yaml:"snapshot_dir" json:"snapshot_dir"`
+	Groups      []GroupConfig `yaml:"groups" json:"groups"`
+}
+
+// ListenConfig holds the addresses the server is reachable at.
+type ListenConfig struct {
+	// Self is the URL peers use to reach this node, e.g. "http://10.0.0.1:8001".
+	Self string `yaml:"self" json:"self"`
+	// Addr is the address the cache server binds to, it defaults to the host of Self.
+	Addr string `yaml:"addr" json:"addr"`
+	// API is the URL of the optional frontend API server.
+	API string `yaml:"api" json:"api"`
+}
+
+// PeersConfig holds where the peer list comes from.
+// Static peers and DNS discovered peers are merged, this node is always part of the ring.
+type PeersConfig struct {
+	Static []string   `yaml:"static" json:"static"`
+	DNS    *DNSConfig `yaml:"dns" json:"dns"`
+	// OpenMembership lets nodes join and leave the rings of their peers,
+	// take load leases and transfer keys, without auth or verify_peers. Anyone reaching the cache port can then
+	// change the ring, only enable it on trusted networks.
+	OpenMembership bool `yaml:"open_membership" json:"open_membership"`
+}
+
+// DNSConfig discovers peers by resolving a DNS name, every address is a peer
+// except those of this node, which is already listen.self.
+type DNSConfig struct {
+	Name     string   `yaml:"name" json:"name"`
+	Port     int      `yaml:"port" json:"port"`
+	Scheme   string   `yaml:"scheme" json:"scheme"`
+	Interval Duration `yaml:"interval" json:"interval"`
+}
+
+// TLSConfig enables TLS on the cache and API servers.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file" json:"cert_file"`
+	KeyFile  string `yaml:"key_file" json:"key_file"`
+	// CAFile holds the CAs peer certificates are verified with, it defaults to the system roots.
+	CAFile string `yaml:"ca_file" json:"ca_file"`
+	// VerifyPeers enables mutual TLS between peers: each presents its
+	// certificate as a client certificate, and requests from hosts that
+	// aren't peers are rejected.
+	VerifyPeers bool `yaml:"verify_peers" json:"verify_peers"`
+}
+
+// AuthConfig authenticates the requests between peers.
+type AuthConfig struct {
+	// Type is "hmac" or "bearer", empty disables authentication.
+	Type string `yaml:"type" json:"type"`
+	// Caller is the name this node signs its requests as with hmac.
+	Caller string `yaml:"caller" json:"caller"`
+	// Secret is shared by every peer with hmac.
+	Secret string `yaml:"secret" json:"secret"`
+	// Token is the bearer token this node sends to its peers.
+	Token string `yaml:"token" json:"token"`
+	// Tokens maps the bearer tokens accepted from peers to caller names.
+	Tokens map[string]string `yaml:"tokens" json:"tokens"`
+}
+
+// TransportConfig tunes the connections to peers, zero values keep the defaults.
+type TransportConfig struct {
+	MaxIdleConnsPerPeer   int      `yaml:"max_idle_conns_per_peer" json:"max_idle_conns_per_peer"`
+	IdleConnTimeout       Duration `yaml:"idle_conn_timeout" json:"idle_conn_timeout"`
+	DialTimeout           Duration `yaml:"dial_timeout" json:"dial_timeout"`
+	ResponseHeaderTimeout Duration `yaml:"response_header_timeout" json:"response_header_timeout"`
+	// H2C talks HTTP/2 without TLS to http:// peers, every peer must run a version that accepts it.
+	H2C bool `yaml:"h2c" json:"h2c"`
+}
+
+// AdmissionConfig limits the get requests the server serves, zero values
+// disable each limit. Rejected requests get 503 with Retry-After.
+type AdmissionConfig struct {
+	// GroupRate and ClientRate are requests per second, per group and per client.
+	GroupRate   float64 `yaml:"group_rate" json:"group_rate"`
+	GroupBurst  int     `yaml:"group_burst" json:"group_burst"`
+	ClientRate  float64 `yaml:"client_rate" json:"client_rate"`
+	ClientBurst int     `yaml:"client_burst" json:"client_burst"`
+	// MaxLoads caps the loads from the loader in flight per group.
+	MaxLoads int `yaml:"max_loads" json:"max_loads"`
+	// MaxConcurrent caps the requests served at once, the others queue.
+	MaxConcurrent int      `yaml:"max_concurrent" json:"max_concurrent"`
+	QueueTarget   Duration `yaml:"queue_target" json:"queue_target"`
+	QueueTimeout  Duration `yaml:"queue_timeout" json:"queue_timeout"`
+}
+
+// ShutdownConfig controls how the server leaves the cluster on SIGTERM.
+type ShutdownConfig struct {
+	// DrainTimeout bounds the wait for in-flight loads, it defaults to 30s.
+	DrainTimeout Duration `yaml:"drain_timeout" json:"drain_timeout"`
+	// HandoffKeys is the number of hottest keys per group handed to their new owners.
+	HandoffKeys int `yaml:"handoff_keys" json:"handoff_keys"`
+}
+
+// RebalanceConfig controls moving cached keys to their new owners after the peer list changes.
+type RebalanceConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// BatchSize is the number of keys per transfer request.
+	BatchSize int `yaml:"batch_size" json:"batch_size"`
+	// Rate is the maximum number of keys sent per second, zero means unlimited.
+	Rate int `yaml:"rate" json:"rate"`
+	// Delay is how long to wait for the peer list to settle before rebalancing.
+	Delay Duration `yaml:"delay" json:"delay"`
+}
+
+// GroupConfig describes a cache group.
+type GroupConfig struct {
+	Name string `yaml:"name" json:"name"`
+	// Size is the cache size of the group in bytes.
+	Size int64 `yaml:"size" json:"size"`
+	// HotSize is the size in bytes of the cache of values loaded from
+	// peers, zero keeps them in the main cache.
+	HotSize  int64    `yaml:"hot_size" json:"hot_size"`
+	Eviction string   `yaml:"eviction" json:"eviction"`
+	TTL      Duration `yaml:"ttl" json:"ttl"`
+	// StaleWhileRevalidate is how long past its ttl a value is served while it is refreshed.
+	StaleWhileRevalidate Duration `yaml:"stale_while_revalidate" json:"stale_while_revalidate"`
+	// RefreshAhead is how long before its ttl a value read is refreshed.
+	RefreshAhead Duration `yaml:"refresh_ahead" json:"refresh_ahead"`
+	// RefreshAheadMinHits is how many cache hits a value needs to be refreshed ahead, it defaults to 2.
+	RefreshAheadMinHits int `yaml:"refresh_ahead_min_hits" json:"refresh_ahead_min_hits"`
+	// NegativeTTL is how long not-found errors of the loader are cached, zero disables it.
+	NegativeTTL Duration     `yaml:"negative_ttl" json:"negative_ttl"`
+	Loader      LoaderConfig `yaml:"loader" json:"loader"`
+	// Disk, if set, keeps values evicted from memory on disk.
+	Disk *DiskConfig `yaml:"disk" json:"disk"`
+	// Filter, if set, rejects keys the loader doesn't list before loading them.
+	Filter *FilterConfig `yaml:"filter" json:"filter"`
+	// Compression, if set, compresses stored values and values sent to peers.
+	Compression *CompressionConfig `yaml:"compression" json:"compression"`
+	// ACL, if set, lists the callers allowed on the group, "*" allowing any.
+	// Peers need read and write.
+	ACL *ACLConfig `yaml:"acl" json:"acl"`
+	// Hedge, if set, also loads keys locally when their owner is slow to answer.
+	Hedge *HedgeConfig `yaml:"hedge" json:"hedge"`
+	// Retries, if set, retries peer loads that failed with a retryable error.
+	Retries *RetryConfig `yaml:"retries" json:"retries"`
+	// LoadLimit, if set, caps the concurrent loads from the loader.
+	LoadLimit *LoadLimitConfig `yaml:"load_limit" json:"load_limit"`
+	// Lease, if set, makes nodes take a lease from the owner of a key
+	// before loading it, so the cluster loads it once. Every node must set it.
+	Lease *LeaseConfig `yaml:"lease" json:"lease"`
+}
+
+// LeaseConfig describes the load leases of a group.
+type LeaseConfig struct {
+	TTL  Duration `yaml:"ttl" json:"ttl"`
+	Wait Duration `yaml:"wait" json:"wait"`
+}
+
+// LoadLimitConfig describes the cap on concurrent loads of a group.
+type LoadLimitConfig struct {
+	MaxConcurrent int `yaml:"max_concurrent" json:"max_concurrent"`
+	// MaxQueue caps the loads waiting for their turn, zero means no cap.
+	MaxQueue     int      `yaml:"max_queue" json:"max_queue"`
+	QueueTimeout Duration `yaml:"queue_timeout" json:"queue_timeout"`
+}
+
+// HedgeConfig describes the hedging of peer loads of a group.
+type HedgeConfig struct {
+	// Percentile of recent peer latencies after which a load is hedged, it defaults to 0.95.
+	Percentile float64  `yaml:"percentile" json:"percentile"`
+	MinDelay   Duration `yaml:"min_delay" json:"min_delay"`
+	MaxDelay   Duration `yaml:"max_delay" json:"max_delay"`
+}
+
+// RetryConfig describes the retries of peer loads of a group.
+type RetryConfig struct {
+	Max        int      `yaml:"max" json:"max"`
+	Backoff    Duration `yaml:"backoff" json:"backoff"`
+	MaxBackoff Duration `yaml:"max_backoff" json:"max_backoff"`
+}
+
+// ACLConfig lists the callers allowed each operation on a group.
+type ACLConfig struct {
+	Read       []string `yaml:"read" json:"read"`
+	Write      []string `yaml:"write" json:"write"`
+	Invalidate []string `yaml:"invalidate" json:"invalidate"`
+}
+
+// CompressionConfig describes the value compression of a group.
+type CompressionConfig struct {
+	// Type is the compression, only "gzip" is supported.
+	Type string `yaml:"type" json:"type"`
+	// Threshold is the size in bytes below which values are stored raw.
+	Threshold int `yaml:"threshold" json:"threshold"`
+}
+
+// FilterConfig describes the Bloom filter of valid keys of a group.
+type FilterConfig struct {
+	// Expected is the number of keys the filter is sized for.
+	Expected uint64 `yaml:"expected" json:"expected"`
+	// FPRate is the false positive rate the filter is sized for, it defaults to 0.01.
+	FPRate float64 `yaml:"fp_rate" json:"fp_rate"`
+	// Interval is how often the filter is rebuilt, zero builds it once.
+	Interval Duration `yaml:"interval" json:"interval"`
+}
+
+// DiskConfig describes the on-disk second tier of a group.
+type DiskConfig struct {
+	// Dir holds the group's log, it must not be shared with another group.
+	Dir string `yaml:"dir" json:"dir"`
+	// Size is the byte budget of the disk tier, zero means unbounded.
+	Size int64 `yaml:"size" json:"size"`
+}
+
+// LoaderConfig describes the backend a group loads missing keys from.
+type LoaderConfig struct {
+	// Type is one of "static", "http" or "dir".
+	Type string `yaml:"type" json:"type"`
+	// Data holds the values of a static loader.
+	Data map[string]string `yaml:"data" json:"data"`
+	// URL is the origin of an http loader, "{key}" is replaced by the escaped key.
+	URL string `yaml:"url" json:"url"`
+	// KeysURL lists the keys of an http loader, one per line, for the key filter.
+	KeysURL string `yaml:"keys_url" json:"keys_url"`
+	// Path is the directory of a dir loader, each key is a file in it.
+	Path    string   `yaml:"path" json:"path"`
+	Timeout Duration `yaml:"timeout" json:"timeout"`
+}
+
+// Duration is a time.Duration read from strings such as "1m30s".
+type Duration time.Duration
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// loadConfig reads the config at path, files ending in ".json" are read as JSON and anything else as YAML.
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, cfg)
+	} else {
+		err = yaml.Unmarshal(data, cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// validate checks the config and fills in defaults.
+func (c *Config) validate() error {
+	self, err := parsePeerURL(c.Listen.Self)
+	if err != nil {
+		return fmt.Errorf("listen.self: %v", err)
+	}
+	if c.Listen.Addr == "" {
+		c.Listen.Addr = self.Host
+	}
+	if self.Scheme == "https" && c.TLS.CertFile == "" {
+		return fmt.Errorf("listen.self is https but tls.cert_file is not set")
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return fmt.Errorf("tls.cert_file and tls.key_file must be set together")
+	}
+	if c.TLS.VerifyPeers && self.Scheme != "https" {
+		return fmt.Errorf("tls.verify_peers needs an https listen.self")
+	}
+	switch c.Auth.Type {
+	case "":
+	case "hmac":
+		if c.Auth.Caller == "" || c.Auth.Secret == "" {
+			return fmt.Errorf("auth: hmac needs a caller and a secret")
+		}
+	case "bearer":
+		if c.Auth.Token == "" || len(c.Auth.Tokens) == 0 {
+			return fmt.Errorf("auth: bearer needs a token and the accepted tokens")
+		}
+	default:
+		return fmt.Errorf("auth: unsupported type %q", c.Auth.Type)
+	}
+	if c.Listen.API != "" {
+		if _, err := parsePeerURL(c.Listen.API); err != nil {
+			return fmt.Errorf("listen.api: %v", err)
+		}
+	}
+	for _, peer := range c.Peers.Static {
+		if _, err := parsePeerURL(peer); err != nil {
+			return fmt.Errorf("peers.static: %v", err)
+		}
+	}
+	if dns := c.Peers.DNS; dns != nil {
+		if dns.Name == "" || dns.Port <= 0 {
+			return fmt.Errorf("peers.dns: name and port are required")
+		}
+		if dns.Scheme == "" {
+			dns.Scheme = self.Scheme
+		}
+		if dns.Interval <= 0 {
+			dns.Interval = Duration(30 * time.Second)
+		}
+	}
+
+	if c.Shutdown.DrainTimeout <= 0 {
+		c.Shutdown.DrainTimeout = Duration(30 * time.Second)
+	}
+	if c.Shutdown.HandoffKeys < 0 {
+		return fmt.Errorf("shutdown.handoff_keys must not be negative")
+	}
+
+	if t := c.Transport; t.MaxIdleConnsPerPeer < 0 || t.IdleConnTimeout < 0 || t.DialTimeout < 0 || t.ResponseHeaderTimeout < 0 {
+		return fmt.Errorf("transport settings must not be negative")
+	}
+	if a := c.Admission; a.GroupRate < 0 || a.GroupBurst < 0 || a.ClientRate < 0 || a.ClientBurst < 0 ||
+		a.MaxLoads < 0 || a.MaxConcurrent < 0 || a.QueueTarget < 0 || a.QueueTimeout < 0 {
+		return fmt.Errorf("admission settings must not be negative")
+	}
+
+	if c.Rebalance.BatchSize < 0 || c.Rebalance.Rate < 0 || c.Rebalance.Delay < 0 {
+		return fmt.Errorf("rebalance settings must not be negative")
+	}
+
+	if len(c.Groups) == 0 {
+		return fmt.Errorf("no groups configured")
+	}
+	names := make(map[string]bool, len(c.Groups))
+	for i := range c.Groups {
+		g := &c.Groups[i]
+		if g.Name == "" {
+			return fmt.Errorf("groups[%d]: name is required", i)
+		}
+		if names[g.Name] {
+			return fmt.Errorf("group %s: defined more than once", g.Name)
+		}
+		names[g.Name] = true
+		if g.Size <= 0 {
+			return fmt.Errorf("group %s: size must be positive", g.Name)
+		}
+		if g.HotSize < 0 {
+			return fmt.Errorf("group %s: hot_size must not be negative", g.Name)
+		}
+		switch g.Eviction {
+		case "":
+			g.Eviction = "lru"
+		case "lru":
+		default:
+			return fmt.Errorf("group %s: unsupported eviction policy %q", g.Name, g.Eviction)
+		}
+		if g.TTL < 0 || g.NegativeTTL < 0 || g.StaleWhileRevalidate < 0 || g.RefreshAhead < 0 {
+			return fmt.Errorf("group %s: durations must not be negative", g.Name)
+		}
+		if g.RefreshAheadMinHits < 0 {
+			return fmt.Errorf("group %s: refresh_ahead_min_hits must not be negative", g.Name)
+		}
+		if g.TTL == 0 && (g.StaleWhileRevalidate > 0 || g.RefreshAhead > 0) {
+			return fmt.Errorf("group %s: stale_while_revalidate and refresh_ahead need a ttl", g.Name)
+		}
+		if g.Disk != nil && (g.Disk.Dir == "" || g.Disk.Size < 0) {
+			return fmt.Errorf("group %s: disk.dir is required and disk.size must not be negative", g.Name)
+		}
+		if f := g.Filter; f != nil {
+			if f.Expected == 0 || f.FPRate < 0 || f.FPRate >= 1 || f.Interval < 0 {
+				return fmt.Errorf("group %s: filter.expected is required, filter.fp_rate must be in [0, 1)", g.Name)
+			}
+			if f.FPRate == 0 {
+				f.FPRate = 0.01
+			}
+			if g.Loader.Type == "http" && g.Loader.KeysURL == "" {
+				return fmt.Errorf("group %s: filter needs loader.keys_url", g.Name)
+			}
+		}
+		if c := g.Compression; c != nil {
+			if c.Type != "gzip" {
+				return fmt.Errorf("group %s: unsupported compression %q", g.Name, c.Type)
+			}
+			if c.Threshold < 0 {
+				return fmt.Errorf("group %s: compression.threshold must not be negative", g.Name)
+			}
+		}
+		if h := g.Hedge; h != nil {
+			if h.Percentile < 0 || h.Percentile >= 1 || h.MinDelay < 0 || h.MaxDelay < 0 {
+				return fmt.Errorf("group %s: hedge.percentile must be in [0, 1), delays must not be negative", g.Name)
+			}
+			if h.MaxDelay > 0 && h.MinDelay > h.MaxDelay {
+				return fmt.Errorf("group %s: hedge.min_delay must not exceed hedge.max_delay", g.Name)
+			}
+		}
+		if l := g.LoadLimit; l != nil && (l.MaxConcurrent <= 0 || l.MaxQueue < 0 || l.QueueTimeout < 0) {
+			return fmt.Errorf("group %s: load_limit.max_concurrent must be positive, its other settings must not be negative", g.Name)
+		}
+		if l := g.Lease; l != nil && (l.TTL < 0 || l.Wait < 0) {
+			return fmt.Errorf("group %s: lease durations must not be negative", g.Name)
+		}
+		if r := g.Retries; r != nil && (r.Max < 0 || r.Backoff < 0 || r.MaxBackoff < 0) {
+			return fmt.Errorf("group %s: retries must not be negative", g.Name)
+		}
+		switch g.Loader.Type {
+		case "static":
+		case "http":
+			if _, err := parsePeerURL(strings.ReplaceAll(g.Loader.URL, "{key}", "")); err != nil {
+				return fmt.Errorf("group %s: loader.url: %v", g.Name, err)
+			}
+		case "dir":
+			if g.Loader.Path == "" {
+				return fmt.Errorf("group %s: loader.path is required", g.Name)
+			}
+		default:
+			return fmt.Errorf("group %s: unknown loader type %q", g.Name, g.Loader.Type)
+		}
+	}
+	return nil
+}
+
+// parsePeerURL parses an absolute http or https URL.
+func parsePeerURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%q: scheme must be http or https", raw)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%q: host is required", raw)
+	}
+	return u, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "mycached.yaml")
+	os.WriteFile(yamlPath, []byte(`
+listen:
+  self: https://cache-1.example.net:8443
+tls:
+  cert_file: tls.crt
+  key_file: tls.key
+groups:
+  - name: scores
+    size: 2048
+    ttl: 1m
+    loader: {type: static, data: {Tom: "630"}}
+`), 0o644)
+	jsonPath := filepath.Join(dir, "mycached.json")
+	os.WriteFile(jsonPath, []byte(`{
+  "listen": {"self": "https://cache-1.example.net:8443"},
+  "tls": {"cert_file": "tls.crt", "key_file": "tls.key"},
+  "groups": [{"name": "scores", "size": 2048, "ttl": "1m", "loader": {"type": "static", "data": {"Tom": "630"}}}]
+}`), 0o644)
+
+	for _, path := range []string{yamlPath, jsonPath} {
+		cfg, err := loadConfig(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if err := cfg.validate(); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if cfg.Listen.Addr != "cache-1.example.net:8443" {
+			t.Errorf("%s: listen addr should default to the host of self, got %q", path, cfg.Listen.Addr)
+		}
+		g := cfg.Groups[0]
+		if g.Eviction != "lru" || time.Duration(g.TTL) != time.Minute || g.Loader.Data["Tom"] != "630" {
+			t.Errorf("%s: unexpected group config %+v", path, g)
+		}
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	group := GroupConfig{Name: "scores", Size: 2048, Loader: LoaderConfig{Type: "static"}}
+	testCases := map[string]Config{
+		"bad scheme":      {Listen: ListenConfig{Self: "localhost:8001"}, Groups: []GroupConfig{group}},
+		"https no tls":    {Listen: ListenConfig{Self: "https://localhost:8001"}, Groups: []GroupConfig{group}},
+		"no groups":       {Listen: ListenConfig{Self: "http://localhost:8001"}},
+		"duplicate group": {Listen: ListenConfig{Self: "http://localhost:8001"}, Groups: []GroupConfig{group, group}},
+		"bad eviction": {Listen: ListenConfig{Self: "http://localhost:8001"},
+			Groups: []GroupConfig{{Name: "scores", Size: 2048, Eviction: "mru", Loader: LoaderConfig{Type: "static"}}}},
+		"bad loader": {Listen: ListenConfig{Self: "http://localhost:8001"},
+			Groups: []GroupConfig{{Name: "scores", Size: 2048, Loader: LoaderConfig{Type: "redis"}}}},
+		"bad auth": {Listen: ListenConfig{Self: "http://localhost:8001"}, Auth: AuthConfig{Type: "hmac"}, Groups: []GroupConfig{group}},
+		"bad compression": {Listen: ListenConfig{Self: "http://localhost:8001"},
+			Groups: []GroupConfig{{Name: "scores", Size: 2048, Compression: &CompressionConfig{Type: "lz4"}, Loader: LoaderConfig{Type: "static"}}}},
+		"bad hedge": {Listen: ListenConfig{Self: "http://localhost:8001"},
+			Groups: []GroupConfig{{Name: "scores", Size: 2048, Hedge: &HedgeConfig{MinDelay: Duration(time.Second), MaxDelay: Duration(time.Millisecond)}, Loader: LoaderConfig{Type: "static"}}}},
+		"negative hot size": {Listen: ListenConfig{Self: "http://localhost:8001"},
+			Groups: []GroupConfig{{Name: "scores", Size: 2048, HotSize: -1, Loader: LoaderConfig{Type: "static"}}}},
+	}
+	for name, cfg := range testCases {
+		if err := cfg.validate(); err == nil {
+			t.Errorf("%s: config should be invalid", name)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mycache"
+	"net"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// peerSource keeps the peer list of an HTTPPool up to date.
+type peerSource struct {
+	self string
+	// listen is the address the cache server binds to.
+	listen string
+	static []string
+	dns    *DNSConfig
+	pool   *mycache.HTTPPool
+	// current is the last peer list handed to the pool.
+	current []string
+}
+
+// refresh recomputes the peer list and updates the pool if it changed.
+func (s *peerSource) refresh(ctx context.Context) error {
+	peers := append([]string{s.self}, s.static...)
+	if s.dns != nil {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, s.dns.Name)
+		if err != nil {
+			return fmt.Errorf("resolving %s: %v", s.dns.Name, err)
+		}
+		for _, addr := range addrs {
+			// the name usually resolves to this node too, which is already self
+			if s.isSelf(addr, s.dns.Port) {
+				continue
+			}
+			peers = append(peers, fmt.Sprintf("%s://%s", s.dns.Scheme, net.JoinHostPort(addr, strconv.Itoa(s.dns.Port))))
+		}
+	}
+	sort.Strings(peers)
+	peers = slices.Compact(peers)
+	if slices.Equal(peers, s.current) {
+		return nil
+	}
+	s.current = peers
+	s.pool.Set(peers...)
+	log.Println("mycached peers:", peers)
+	return nil
+}
+
+// isSelf reports whether ip and port reach this node: they are the host of
+// self, or the port the node listens on at one of its own addresses.
+func (s *peerSource) isSelf(ip string, port int) bool {
+	addr := net.JoinHostPort(ip, strconv.Itoa(port))
+	if u, err := url.Parse(s.self); err == nil && u.Host == addr {
+		return true
+	}
+	host, listenPort, err := net.SplitHostPort(s.listen)
+	if err != nil || listenPort != strconv.Itoa(port) {
+		return false
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	// a server bound to one address only answers there
+	if bound := net.ParseIP(host); bound != nil && !bound.IsUnspecified() {
+		return parsed.Equal(bound)
+	}
+	if parsed.IsLoopback() {
+		return true
+	}
+	locals, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, local := range locals {
+		if n, ok := local.(*net.IPNet); ok && n.IP.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch refreshes the peer list every interval until ctx is done.
+// It only has work to do when peers come from DNS.
+func (s *peerSource) watch(ctx context.Context) {
+	if s.dns == nil {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(s.dns.Interval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.refresh(ctx); err != nil {
+				log.Println("mycached: refreshing peers:", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"mycache"
+	"slices"
+	"testing"
+)
+
+func TestPeerSourceSkipsSelf(t *testing.T) {
+	self := "http://10.0.0.1:8000"
+	for _, tt := range []struct {
+		listen string
+		ip     string
+		port   int
+		want   bool
+	}{
+		{"10.0.0.1:8000", "10.0.0.1", 8000, true},
+		{":8000", "127.0.0.1", 8000, true},
+		{":8000", "::1", 8000, true},
+		{":8000", "127.0.0.1", 8001, false},
+		{"127.0.0.1:8000", "127.0.0.1", 8000, true},
+		{"127.0.0.2:8000", "127.0.0.1", 8000, false},
+		{":8000", "192.0.2.1", 8000, false},
+	} {
+		s := &peerSource{self: self, listen: tt.listen}
+		if got := s.isSelf(tt.ip, tt.port); got != tt.want {
+			t.Errorf("listening on %s, isSelf(%s, %d) = %v, want %v", tt.listen, tt.ip, tt.port, got, tt.want)
+		}
+	}
+
+	// the resolved addresses of this node don't join the ring
+	s := &peerSource{
+		self:   self,
+		listen: ":8000",
+		dns:    &DNSConfig{Name: "localhost", Port: 8000, Scheme: "http"},
+		pool:   mycache.NewHTTPPool(self),
+	}
+	if err := s.refresh(context.Background()); err != nil {
+		t.Skipf("resolving localhost: %v", err)
+	}
+	if !slices.Equal(s.current, []string{self}) {
+		t.Errorf("addresses of this node should be skipped, got %v", s.current)
+	}
+}
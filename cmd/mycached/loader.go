@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mycache"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultLoaderTimeout = 5 * time.Second
+
+// newGetter returns the Getter for a loader backend.
+func newGetter(cfg LoaderConfig) (mycache.Getter, error) {
+	switch cfg.Type {
+	case "static":
+		return staticGetter(cfg.Data), nil
+	case "http":
+		return newHTTPGetter(cfg), nil
+	case "dir":
+		return dirGetter(cfg.Path), nil
+	}
+	return nil, fmt.Errorf("unknown loader type %q", cfg.Type)
+}
+
+// staticGetter loads keys from a fixed map, mostly useful for testing.
+func staticGetter(data map[string]string) mycache.Getter {
+	return mycache.GetterFunc(func(key string) ([]byte, error) {
+		log.Println("[StaticDB] search key", key)
+		if v, ok := data[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%s not exist: %w", key, mycache.ErrNotFound)
+	})
+}
+
+// newHTTPGetter loads keys with a GET request to an origin server.
+func newHTTPGetter(cfg LoaderConfig) mycache.Getter {
+	timeout := time.Duration(cfg.Timeout)
+	if timeout <= 0 {
+		timeout = defaultLoaderTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+	return mycache.GetterFunc(func(key string) ([]byte, error) {
+		u := strings.ReplaceAll(cfg.URL, "{key}", url.QueryEscape(key))
+		res, err := client.Get(u)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+
+		switch res.StatusCode {
+		case http.StatusOK:
+		case http.StatusNotFound:
+			return nil, fmt.Errorf("%s not exist: %w", key, mycache.ErrNotFound)
+		default:
+			return nil, fmt.Errorf("origin return: %v", res.Status)
+		}
+		return io.ReadAll(res.Body)
+	})
+}
+
+// dirGetter loads each key from the file of the same name under root.
+func dirGetter(root string) mycache.Getter {
+	return mycache.GetterFunc(func(key string) ([]byte, error) {
+		// keys must not escape root
+		if !fs.ValidPath(key) {
+			return nil, fmt.Errorf("invalid key %q", key)
+		}
+		b, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(key)))
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s not exist: %w", key, mycache.ErrNotFound)
+		}
+		return b, err
+	})
+}
+
+// newKeySource returns the KeySource listing the keys of a loader backend.
+func newKeySource(cfg LoaderConfig) (mycache.KeySource, error) {
+	switch cfg.Type {
+	case "static":
+		return func(add func(string)) error {
+			for k := range cfg.Data {
+				add(k)
+			}
+			return nil
+		}, nil
+	case "http":
+		client := &http.Client{Timeout: max(time.Duration(cfg.Timeout), defaultLoaderTimeout)}
+		return func(add func(string)) error {
+			res, err := client.Get(cfg.KeysURL)
+			if err != nil {
+				return err
+			}
+			defer res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				return fmt.Errorf("origin return: %v", res.Status)
+			}
+			scanner := bufio.NewScanner(res.Body)
+			for scanner.Scan() {
+				if key := scanner.Text(); key != "" {
+					add(key)
+				}
+			}
+			return scanner.Err()
+		}, nil
+	case "dir":
+		return func(add func(string)) error {
+			return filepath.WalkDir(cfg.Path, func(path string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				rel, err := filepath.Rel(cfg.Path, path)
+				if err != nil {
+					return err
+				}
+				add(filepath.ToSlash(rel))
+				return nil
+			})
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown loader type %q", cfg.Type)
+}
@@ -0,0 +1,356 @@
+// Command mycached runs a mycache node configured from a YAML or JSON file.
+//
+//	mycached -config mycached.yaml [-self http://10.0.0.1:8001] [-api http://10.0.0.1:9999] [-peers url,url]
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"mycache"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+func main() {
+	var configPath, self, api, peers string
+	flag.StringVar(&configPath, "config", "mycached.yaml", "path to the YAML or JSON config file")
+	flag.StringVar(&self, "self", "", "URL peers use to reach this node, overrides listen.self")
+	flag.StringVar(&api, "api", "", "URL of the frontend API server, overrides listen.api")
+	flag.StringVar(&peers, "peers", "", "comma separated peer URLs, overrides peers.static")
+	flag.Parse()
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if self != "" {
+		cfg.Listen.Self = self
+		cfg.Listen.Addr = ""
+	}
+	if api != "" {
+		cfg.Listen.API = api
+	}
+	if peers != "" {
+		cfg.Peers.Static = strings.Split(peers, ",")
+	}
+	if err := cfg.validate(); err != nil {
+		log.Fatal("invalid config: ", err)
+	}
+
+	groups, err := createGroups(cfg.Groups)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts, err := poolOptions(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pool := mycache.NewHTTPPoolOpts(cfg.Listen.Self, opts)
+	for _, g := range groups {
+		g.RegisterPeers(pool)
+	}
+	if cfg.Rebalance.Enabled {
+		r := mycache.NewRebalancer(pool)
+		if cfg.Rebalance.BatchSize > 0 {
+			r.BatchSize = cfg.Rebalance.BatchSize
+		}
+		if cfg.Rebalance.Delay > 0 {
+			r.Delay = time.Duration(cfg.Rebalance.Delay)
+		}
+		r.Rate = cfg.Rebalance.Rate
+	}
+	source := &peerSource{
+		self:   cfg.Listen.Self,
+		listen: cfg.Listen.Addr,
+		static: cfg.Peers.Static,
+		dns:    cfg.Peers.DNS,
+		pool:   pool,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := source.refresh(ctx); err != nil {
+		log.Println("mycached: refreshing peers:", err)
+	}
+	go source.watch(ctx)
+
+	errc := make(chan error, 2)
+	srv := newCacheServer(cfg, pool)
+	go func() {
+		log.Println("mycache is running at", cfg.Listen.Self)
+		errc <- srv.ListenAndServe()
+	}()
+	var apiSrv *http.Server
+	if cfg.Listen.API != "" {
+		if apiSrv, err = newAPIServer(cfg, groups); err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			log.Println("frontend server is running at", cfg.Listen.API)
+			errc <- listenAndServe(cfg, apiSrv)
+		}()
+	}
+
+	select {
+	case err := <-errc:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+	stop()
+	log.Println("mycached: shutting down")
+	if err := shutdown(cfg, srv, apiSrv); err != nil {
+		log.Fatal("mycached: ", err)
+	}
+}
+
+// shutdown drains the cache server and the API server concurrently within the drain timeout.
+func shutdown(cfg *Config, srv *mycache.Server, api *http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Shutdown.DrainTimeout))
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var apiErr error
+	if api != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			apiErr = api.Shutdown(ctx)
+		}()
+	}
+	err := srv.Shutdown(ctx)
+	wg.Wait()
+	return errors.Join(err, apiErr)
+}
+
+// createGroups creates the configured groups.
+func createGroups(configs []GroupConfig) (map[string]*mycache.Group, error) {
+	groups := make(map[string]*mycache.Group, len(configs))
+	for _, c := range configs {
+		getter, err := newGetter(c.Loader)
+		if err != nil {
+			return nil, err
+		}
+		opts := []mycache.Option{
+			mycache.WithCacheBytes(c.Size),
+			mycache.WithHotCacheBytes(c.HotSize),
+			mycache.WithTTL(time.Duration(c.TTL)),
+			mycache.WithNegativeTTL(time.Duration(c.NegativeTTL)),
+			mycache.WithStaleWhileRevalidate(time.Duration(c.StaleWhileRevalidate)),
+			mycache.WithRefreshAhead(time.Duration(c.RefreshAhead)),
+		}
+		if c.RefreshAheadMinHits > 0 {
+			opts = append(opts, mycache.WithRefreshAheadMinHits(c.RefreshAheadMinHits))
+		}
+		if c.Compression != nil {
+			opts = append(opts, mycache.WithCompression(mycache.Gzip, c.Compression.Threshold))
+		}
+		if h := c.Hedge; h != nil {
+			opts = append(opts, mycache.WithHedging(mycache.HedgeOptions{
+				Percentile: h.Percentile,
+				MinDelay:   time.Duration(h.MinDelay),
+				MaxDelay:   time.Duration(h.MaxDelay),
+			}))
+		}
+		if l := c.LoadLimit; l != nil {
+			opts = append(opts, mycache.WithLoadLimit(mycache.LoadLimitOptions{
+				MaxConcurrent: l.MaxConcurrent,
+				MaxQueue:      l.MaxQueue,
+				QueueTimeout:  time.Duration(l.QueueTimeout),
+			}))
+		}
+		if l := c.Lease; l != nil {
+			opts = append(opts, mycache.WithLoadLease(mycache.LeaseOptions{TTL: time.Duration(l.TTL), Wait: time.Duration(l.Wait)}))
+		}
+		if r := c.Retries; r != nil {
+			opts = append(opts, mycache.WithRetries(mycache.RetryOptions{
+				MaxRetries: r.Max,
+				Backoff:    time.Duration(r.Backoff),
+				MaxBackoff: time.Duration(r.MaxBackoff),
+			}))
+		}
+		if c.Disk != nil {
+			opts = append(opts, mycache.WithDiskCache(c.Disk.Dir, c.Disk.Size))
+		}
+		if f := c.Filter; f != nil {
+			src, err := newKeySource(c.Loader)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, mycache.WithKeyFilter(src, f.Expected, f.FPRate, time.Duration(f.Interval)))
+		}
+		g, err := mycache.NewGroup(c.Name, getter, opts...)
+		if err != nil {
+			return nil, err
+		}
+		groups[c.Name] = g
+	}
+	return groups, nil
+}
+
+// poolOptions sets up the authentication of peers, the group ACLs and the
+// TLS configuration of the peer server and client.
+func poolOptions(cfg *Config) (*mycache.HTTPPoolOptions, error) {
+	c := cfg.TLS
+	t := cfg.Transport
+	opts := &mycache.HTTPPoolOptions{
+		VerifyPeers:    c.VerifyPeers,
+		OpenMembership: cfg.Peers.OpenMembership,
+		TransportOptions: mycache.TransportOptions{
+			MaxIdleConnsPerPeer:   t.MaxIdleConnsPerPeer,
+			IdleConnTimeout:       time.Duration(t.IdleConnTimeout),
+			DialTimeout:           time.Duration(t.DialTimeout),
+			ResponseHeaderTimeout: time.Duration(t.ResponseHeaderTimeout),
+			H2C:                   t.H2C,
+		},
+		Admission: mycache.AdmissionOptions{
+			GroupRate:     cfg.Admission.GroupRate,
+			GroupBurst:    cfg.Admission.GroupBurst,
+			ClientRate:    cfg.Admission.ClientRate,
+			ClientBurst:   cfg.Admission.ClientBurst,
+			MaxLoads:      cfg.Admission.MaxLoads,
+			MaxConcurrent: cfg.Admission.MaxConcurrent,
+			QueueTarget:   time.Duration(cfg.Admission.QueueTarget),
+			QueueTimeout:  time.Duration(cfg.Admission.QueueTimeout),
+		},
+	}
+	switch a := cfg.Auth; a.Type {
+	case "hmac":
+		opts.Auth = &mycache.HMACAuth{Caller: a.Caller, Secret: []byte(a.Secret)}
+	case "bearer":
+		opts.Auth = &mycache.BearerAuth{Token: a.Token, Callers: a.Tokens}
+	}
+	for _, g := range cfg.Groups {
+		if g.ACL == nil {
+			continue
+		}
+		if opts.ACLs == nil {
+			// groups without an acl stay open
+			opts.ACLs = make(map[string]mycache.GroupACL, len(cfg.Groups))
+			for _, g := range cfg.Groups {
+				opts.ACLs[g.Name] = mycache.GroupACL{
+					Read:       []string{mycache.AnyCaller},
+					Write:      []string{mycache.AnyCaller},
+					Invalidate: []string{mycache.AnyCaller},
+				}
+			}
+		}
+		opts.ACLs[g.Name] = mycache.GroupACL{Read: g.ACL.Read, Write: g.ACL.Write, Invalidate: g.ACL.Invalidate}
+	}
+	if c.CAFile == "" && !c.VerifyPeers {
+		return opts, nil
+	}
+	server := &tls.Config{MinVersion: tls.VersionTLS12}
+	client := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cas := x509.NewCertPool()
+		if !cas.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls.ca_file: no certificates in %s", c.CAFile)
+		}
+		server.ClientCAs, client.RootCAs = cas, cas
+	}
+	if c.VerifyPeers {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		server.ClientAuth = tls.RequireAndVerifyClientCert
+		client.Certificates = []tls.Certificate{cert}
+	}
+	opts.TLSConfig, opts.TransportOptions.TLSClientConfig = server, client
+	return opts, nil
+}
+
+func newCacheServer(cfg *Config, pool *mycache.HTTPPool) *mycache.Server {
+	srv := mycache.NewServer(pool, cfg.Listen.Addr)
+	srv.DrainTimeout = time.Duration(cfg.Shutdown.DrainTimeout)
+	srv.HandoffKeys = cfg.Shutdown.HandoffKeys
+	srv.CertFile, srv.KeyFile = cfg.TLS.CertFile, cfg.TLS.KeyFile
+	srv.SnapshotDir = cfg.SnapshotDir
+	return srv
+}
+
+// newAPIServer serves /api?group=<group>&key=<key>, the group may be omitted
+// when only one is configured, and the statistics of every group on /stats.
+func newAPIServer(cfg *Config, groups map[string]*mycache.Group) (*http.Server, error) {
+	u, err := url.Parse(cfg.Listen.API)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("group")
+		if name == "" && len(cfg.Groups) == 1 {
+			name = cfg.Groups[0].Name
+		}
+		g, ok := groups[name]
+		if !ok {
+			http.Error(w, "no such group: "+name, http.StatusNotFound)
+			return
+		}
+		view, err := g.Get(r.URL.Query().Get("key"))
+		if mycache.IsNotFound(err) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		view.WriteTo(w)
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(groupStats(groups))
+	})
+	return &http.Server{Addr: u.Host, Handler: mux}, nil
+}
+
+// groupStatsJSON is the /stats entry of a group.
+type groupStatsJSON struct {
+	*mycache.Stats
+	CompressionRatio float64              `json:",omitempty"`
+	Filter           *mycache.FilterStats `json:",omitempty"`
+	// MeanLoadQueueWait is in nanoseconds.
+	MeanLoadQueueWait time.Duration `json:",omitempty"`
+}
+
+func groupStats(groups map[string]*mycache.Group) map[string]groupStatsJSON {
+	stats := make(map[string]groupStatsJSON, len(groups))
+	for name, g := range groups {
+		s := groupStatsJSON{
+			Stats:             &g.Stats,
+			CompressionRatio:  g.Stats.CompressionRatio(),
+			MeanLoadQueueWait: g.Stats.MeanLoadQueueWait(),
+		}
+		if f, ok := g.FilterStats(); ok {
+			s.Filter = &f
+		}
+		stats[name] = s
+	}
+	return stats
+}
+
+// listenAndServe runs srv, over TLS when a certificate is configured.
+func listenAndServe(cfg *Config, srv *http.Server) error {
+	if cfg.TLS.CertFile != "" {
+		return srv.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	}
+	return srv.ListenAndServe()
+}
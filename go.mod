@@ -1,12 +1,15 @@
 module example
 
-go 1.22.0
+go 1.24.0
 
 require (
-	google.golang.org/protobuf v1.36.6
+	gopkg.in/yaml.v3 v3.0.1
 	mycache v0.0.0
 )
 
-require github.com/golang/protobuf v1.5.4 // indirect
+require (
+	github.com/golang/protobuf v1.5.4 // indirect
+	google.golang.org/protobuf v1.36.6 // indirect
+)
 
 replace mycache => ./mycache
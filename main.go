@@ -7,6 +7,8 @@ import (
 
 	"log"
 	"net/http"
+	"net/url"
+	"time"
 )
 
 var db = map[string]string{
@@ -16,14 +18,21 @@ var db = map[string]string{
 }
 
 func createGroup() *mycache.Group {
-	return mycache.NewGroup("scores", 2<<10, mycache.GetterFunc(
+	g, err := mycache.NewGroup("scores", mycache.GetterFunc(
 		func(key string) ([]byte, error) {
 			log.Println("[SlowDB] search key", key)
 			if v, ok := db[key]; ok {
 				return []byte(v), nil
 			}
-			return nil, fmt.Errorf("%s not exist", key)
-		}))
+			return nil, fmt.Errorf("%s not exist: %w", key, mycache.ErrNotFound)
+		}),
+		mycache.WithCacheBytes(2<<10),
+		// don't let requests for missing keys hammer the slow DB
+		mycache.WithNegativeTTL(10*time.Second))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return g
 }
 
 func startCacheServer(addr string, addrs []string, gee *mycache.Group) {
@@ -31,7 +40,7 @@ func startCacheServer(addr string, addrs []string, gee *mycache.Group) {
 	peers.Set(addrs...)
 	gee.RegisterPeers(peers)
 	log.Println("mycache is running at addr", addr)
-	log.Fatal(http.ListenAndServe(addr[7:], peers))
+	log.Fatal(http.ListenAndServe(hostOf(addr), peers))
 }
 
 func startAPIServer(apiAddr string, gee *mycache.Group) {
@@ -44,10 +53,19 @@ func startAPIServer(apiAddr string, gee *mycache.Group) {
 				return
 			}
 			w.Header().Set("Content-Type", "application/octet-stream")
-			w.Write(view.ByteSlice())
+			view.WriteTo(w)
 		}))
 	log.Println("frontend sever is running at", apiAddr)
-	log.Fatal(http.ListenAndServe(apiAddr[7:], nil))
+	log.Fatal(http.ListenAndServe(hostOf(apiAddr), nil))
+}
+
+// hostOf returns the host:port part of an http or https URL.
+func hostOf(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil {
+		log.Fatalf("invalid address %s: %v", addr, err)
+	}
+	return u.Host
 }
 
 func main() {
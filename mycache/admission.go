@@ -0,0 +1,262 @@
+package mycache
+
+import (
+	"fmt"
+	"math"
+	"mycache/lru"
+	pb "mycache/mycachepb"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultQueueTarget  = 10 * time.Millisecond
+	defaultQueueTimeout = time.Second
+	// queueInterval is how long the queueing delay must stay over target
+	// before requests are shed.
+	queueInterval = 100 * time.Millisecond
+	// maxClientBuckets is the number of clients whose rate limits are
+	// tracked, past it those of the least recently seen are forgotten.
+	maxClientBuckets = 10000
+	// overloadRetryAfter is the Retry-After of requests shed for overload.
+	overloadRetryAfter = time.Second
+)
+
+// AdmissionOptions configure the admission control of a HTTPPool. Get
+// requests it doesn't admit are rejected with 503 Service Unavailable and a
+// Retry-After header, which peers take as a signal to load the key
+// themselves. The zero value admits every request.
+type AdmissionOptions struct {
+	// GroupRate limits the requests per second to each group, with bursts
+	// of up to GroupBurst requests. Zero disables the limit.
+	GroupRate  float64
+	GroupBurst int
+	// ClientRate limits the requests per second of each client, named by
+	// the pool's Authenticator or else by its IP address, with bursts of up
+	// to ClientBurst requests. Zero disables the limit.
+	ClientRate  float64
+	ClientBurst int
+	// MaxLoads caps the loads from the Getter in flight in a group, not
+	// counting the requests waiting for them or loads from peers: while it
+	// is reached, requests for keys that aren't cached are rejected. Zero
+	// disables it.
+	MaxLoads int
+	// MaxConcurrent caps the requests served at once, the others wait in a
+	// queue. Zero disables the cap, and load shedding with it.
+	MaxConcurrent int
+	// QueueTarget is the queueing delay load shedding aims for, it defaults
+	// to 10ms. Once every request queued for 100ms waited longer than it,
+	// requests that would have to wait are rejected until one is served
+	// right away again.
+	QueueTarget time.Duration
+	// QueueTimeout is the longest a request waits in the queue, it
+	// defaults to one second.
+	QueueTimeout time.Duration
+}
+
+// admission implements AdmissionOptions.
+type admission struct {
+	AdmissionOptions
+
+	mu sync.Mutex
+	// groups and clients are the token buckets of the rate limits.
+	groups  *lru.TypedCache[string, *tokenBucket]
+	clients *lru.TypedCache[string, *tokenBucket]
+
+	// slots holds a token for every request being served.
+	slots chan struct{}
+	// shedding is set while the queueing delay is over target.
+	shedding atomic.Bool
+	qmu      sync.Mutex
+	// minDelay is the shortest queueing delay seen in the interval ending
+	// at intervalEnd, -1 if none was.
+	minDelay    time.Duration
+	intervalEnd time.Time
+}
+
+// newAdmission returns the admission control for o, or nil if o admits everything.
+func newAdmission(o AdmissionOptions) *admission {
+	if o == (AdmissionOptions{}) {
+		return nil
+	}
+	if o.QueueTarget <= 0 {
+		o.QueueTarget = defaultQueueTarget
+	}
+	if o.QueueTimeout <= 0 {
+		o.QueueTimeout = defaultQueueTimeout
+	}
+	a := &admission{
+		AdmissionOptions: o,
+		groups:           lru.NewTypedCache[string, *tokenBucket](0, nil, nil),
+		clients:          lru.NewTypedCache[string, *tokenBucket](maxClientBuckets, nil, nil),
+		minDelay:         -1,
+	}
+	if o.MaxConcurrent > 0 {
+		a.slots = make(chan struct{}, o.MaxConcurrent)
+	}
+	return a
+}
+
+// admit decides whether the pool serves the request r of caller for key in
+// group. If it does it returns a func to call once the request is served,
+// otherwise it has rejected the request.
+func (p *HTTPPool) admit(w http.ResponseWriter, r *http.Request, caller string, group *Group, key string) (done func(), ok bool) {
+	a := p.admission
+	if a == nil {
+		return func() {}, true
+	}
+	reject := func(retryAfter time.Duration, format string, v ...interface{}) (func(), bool) {
+		group.Stats.ServerRejects.Add(1)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		writeError(w, &PeerError{Code: pb.Code_UNAVAILABLE, Message: fmt.Sprintf(format, v...)})
+		return nil, false
+	}
+
+	if caller == "" {
+		caller, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
+	if wait, ok := a.limit(a.clients, caller, a.ClientRate, a.ClientBurst); !ok {
+		return reject(wait, "client %q is over its rate limit", caller)
+	}
+	if wait, ok := a.limit(a.groups, group.name, a.GroupRate, a.GroupBurst); !ok {
+		return reject(wait, "group %s is over its rate limit", group.name)
+	}
+	if a.MaxLoads > 0 && group.originLoads.Load() >= int64(a.MaxLoads) && !group.cached(key) {
+		return reject(overloadRetryAfter, "group %s has too many loads in flight", group.name)
+	}
+	if a.slots == nil {
+		return func() {}, true
+	}
+	if !a.acquire(r) {
+		return reject(overloadRetryAfter, "server is overloaded")
+	}
+	return func() { <-a.slots }, true
+}
+
+// limit takes a token from the bucket of name in buckets, if rate is set.
+// If there is none it returns how long until there is.
+func (a *admission) limit(buckets *lru.TypedCache[string, *tokenBucket], name string, rate float64, burst int) (wait time.Duration, ok bool) {
+	if rate <= 0 {
+		return 0, true
+	}
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	b, ok := buckets.Get(name)
+	if !ok {
+		if burst <= 0 {
+			burst = int(math.Ceil(rate))
+		}
+		b = &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+		buckets.Add(name, b)
+	}
+	return b.take(now)
+}
+
+// acquire waits for a slot to serve r in, unless the queue is shedding
+// requests, r is canceled or QueueTimeout passes.
+func (a *admission) acquire(r *http.Request) bool {
+	select {
+	case a.slots <- struct{}{}:
+		a.observe(0)
+		return true
+	default:
+	}
+	if a.shedding.Load() {
+		return false
+	}
+	start := time.Now()
+	timer := time.NewTimer(a.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case a.slots <- struct{}{}:
+		a.observe(time.Since(start))
+		return true
+	case <-timer.C:
+		a.observe(a.QueueTimeout)
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// observe records the queueing delay of a request. At the end of every
+// interval, requests are shed if none waited less than QueueTarget.
+func (a *admission) observe(d time.Duration) {
+	now := time.Now()
+	a.qmu.Lock()
+	defer a.qmu.Unlock()
+	if now.After(a.intervalEnd) {
+		a.shedding.Store(a.minDelay > a.QueueTarget)
+		a.minDelay = d
+		a.intervalEnd = now.Add(queueInterval)
+		return
+	}
+	if a.minDelay < 0 || d < a.minDelay {
+		a.minDelay = d
+	}
+}
+
+// tokenBucket is a token bucket rate limiter, guarded by admission.mu.
+type tokenBucket struct {
+	// rate is the tokens added per second, up to burst.
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens since the last refill and returns the tokens.
+func (b *tokenBucket) refill(now time.Time) float64 {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	return b.tokens
+}
+
+// take takes a token, or returns how long until there is one.
+func (b *tokenBucket) take(now time.Time) (wait time.Duration, ok bool) {
+	if b.refill(now) >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second)), false
+}
+
+// retryAfter parses the Retry-After header of res, in seconds or as a date.
+func retryAfter(res *http.Response) (time.Duration, bool) {
+	v := res.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
+
+// backOff makes h reject requests until the time a 503 response asked to
+// retry after, so that they are loaded locally instead of adding to the
+// peer's load.
+func (h *httpGetter) backOff(res *http.Response) {
+	if res.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+	if d, ok := retryAfter(res); ok && d > 0 {
+		h.retryAt.Store(time.Now().Add(d).UnixNano())
+	}
+}
+
+// backingOff returns an error while h backs off from an overloaded peer.
+func (h *httpGetter) backingOff() error {
+	if t := h.retryAt.Load(); t != 0 && time.Now().UnixNano() < t {
+		return &PeerError{Code: pb.Code_UNAVAILABLE, Message: "peer is overloaded, backing off"}
+	}
+	return nil
+}
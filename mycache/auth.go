@@ -0,0 +1,204 @@
+package mycache
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An Authenticator authenticates the requests between peers of an
+// HTTPPool, see HTTPPoolOptions.
+type Authenticator interface {
+	// Sign adds this node's credentials to a request to a peer.
+	Sign(r *http.Request) error
+	// Authenticate returns the name of the caller making r, or an error if
+	// r doesn't carry valid credentials.
+	Authenticate(r *http.Request) (caller string, err error)
+}
+
+// Headers of requests signed by HMACAuth.
+const (
+	callerHeader    = "X-Mycache-Caller"
+	timestampHeader = "X-Mycache-Timestamp"
+	signatureHeader = "X-Mycache-Signature"
+)
+
+const defaultMaxSkew = 5 * time.Minute
+
+// HMACAuth signs requests with a secret shared by every peer. The signature
+// covers the caller, the time, the method, the URL and the body of the request.
+// Since the secret is shared, any peer can sign as any caller.
+type HMACAuth struct {
+	// Caller is the name this node signs its requests as.
+	Caller string
+	Secret []byte
+	// MaxSkew is how old or how far in the future a signed request may be,
+	// it defaults to 5 minutes.
+	MaxSkew time.Duration
+}
+
+// Sign implements Authenticator.
+func (a *HMACAuth) Sign(r *http.Request) error {
+	body, err := requestBody(r)
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	r.Header.Set(callerHeader, a.Caller)
+	r.Header.Set(timestampHeader, ts)
+	r.Header.Set(signatureHeader, hex.EncodeToString(a.sign(a.Caller, ts, r, body)))
+	return nil
+}
+
+// Authenticate implements Authenticator.
+func (a *HMACAuth) Authenticate(r *http.Request) (string, error) {
+	caller, ts := r.Header.Get(callerHeader), r.Header.Get(timestampHeader)
+	sig, err := hex.DecodeString(r.Header.Get(signatureHeader))
+	if err != nil || len(sig) == 0 {
+		return "", errors.New("missing or malformed signature")
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", errors.New("malformed timestamp")
+	}
+	maxSkew := a.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = defaultMaxSkew
+	}
+	if d := time.Since(time.Unix(sec, 0)); d > maxSkew || d < -maxSkew {
+		return "", errors.New("request signed too long ago")
+	}
+	body, err := requestBody(r)
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal(sig, a.sign(caller, ts, r, body)) {
+		return "", errors.New("invalid signature")
+	}
+	return caller, nil
+}
+
+func (a *HMACAuth) sign(caller, ts string, r *http.Request, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, a.Secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%x", caller, ts, r.Method, r.URL.RequestURI(), sum)
+	return mac.Sum(nil)
+}
+
+// requestBody returns the body of r and leaves r with an unread copy of it.
+func requestBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := readBody(r)
+	r.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading request body: %v", err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// BearerAuth authenticates requests with bearer tokens.
+type BearerAuth struct {
+	// Token is sent with this node's requests.
+	Token string
+	// Callers maps the accepted tokens to the names of their callers.
+	Callers map[string]string
+}
+
+// Sign implements Authenticator.
+func (a *BearerAuth) Sign(r *http.Request) error {
+	r.Header.Set("Authorization", "Bearer "+a.Token)
+	return nil
+}
+
+// Authenticate implements Authenticator.
+func (a *BearerAuth) Authenticate(r *http.Request) (string, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", errors.New("missing bearer token")
+	}
+	for t, caller := range a.Callers {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return caller, nil
+		}
+	}
+	return "", errors.New("invalid bearer token")
+}
+
+// A Permission is an operation on a group.
+type Permission int
+
+const (
+	// PermRead allows getting values from the group.
+	PermRead Permission = iota
+	// PermWrite allows storing values in the group, as peers moving keys do.
+	PermWrite
+	// PermInvalidate allows removing keys from the group.
+	PermInvalidate
+)
+
+// AnyCaller in a GroupACL allows every authenticated caller.
+const AnyCaller = "*"
+
+// A GroupACL lists the callers allowed each operation on a group.
+type GroupACL struct {
+	Read, Write, Invalidate []string
+}
+
+// allows reports whether caller may do perm.
+func (acl GroupACL) allows(caller string, perm Permission) bool {
+	var callers []string
+	switch perm {
+	case PermRead:
+		callers = acl.Read
+	case PermWrite:
+		callers = acl.Write
+	case PermInvalidate:
+		callers = acl.Invalidate
+	}
+	for _, c := range callers {
+		if c == AnyCaller || c == caller {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed reports whether caller may do perm on group.
+func (p *HTTPPool) allowed(caller, group string, perm Permission) bool {
+	if p.acls == nil {
+		return true
+	}
+	acl, ok := p.acls[group]
+	return ok && acl.allows(caller, perm)
+}
+
+// mayChangeRing reports whether caller may join or leave the ring. That
+// moves keys of every group, so it needs write permission on all of them.
+func (p *HTTPPool) mayChangeRing(caller string) bool {
+	if p.acls == nil {
+		return true
+	}
+	for group := range p.acls {
+		if !p.allowed(caller, group, PermWrite) {
+			return false
+		}
+	}
+	for _, g := range p.registry.Groups() {
+		if !p.allowed(caller, g.name, PermWrite) {
+			return false
+		}
+	}
+	return true
+}
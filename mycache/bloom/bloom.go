@@ -0,0 +1,91 @@
+package bloom
+
+import (
+	"hash/fnv"
+	"math"
+	"sync/atomic"
+)
+
+// Filter is a Bloom filter: Test never reports false for an added key, and
+// reports true for a key that was never added at the false positive rate the
+// filter was sized for. It is safe for concurrent use.
+type Filter struct {
+	bits []uint64
+	// m is the number of bits and k the number of hash functions.
+	m uint64
+	k uint64
+	// n is the number of keys added.
+	n atomic.Uint64
+}
+
+// New creates a filter sized to hold n keys at a false positive rate of p.
+func New(n uint64, p float64) *Filter {
+	if n == 0 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	// m = -n ln p / (ln 2)^2, k = m/n ln 2
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	m = max((m+63)/64*64, 64)
+	k := uint64(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	return &Filter{
+		bits: make([]uint64, m/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// hashes returns the two hashes that the k bit positions of key are derived from.
+func hashes(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	return sum & 0xffffffff, sum>>32 | 1
+}
+
+// Add adds key to the filter.
+func (f *Filter) Add(key string) {
+	h1, h2 := hashes(key)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		word, mask := &f.bits[bit/64], uint64(1)<<(bit%64)
+		for {
+			old := atomic.LoadUint64(word)
+			if old&mask != 0 || atomic.CompareAndSwapUint64(word, old, old|mask) {
+				break
+			}
+		}
+	}
+	f.n.Add(1)
+}
+
+// Test reports whether key may have been added. False means it definitely was not.
+func (f *Filter) Test(key string) bool {
+	h1, h2 := hashes(key)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if atomic.LoadUint64(&f.bits[bit/64])&(uint64(1)<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Len returns the number of keys added.
+func (f *Filter) Len() uint64 {
+	return f.n.Load()
+}
+
+// Bits returns the size of the filter in bits.
+func (f *Filter) Bits() uint64 {
+	return f.m
+}
+
+// FalsePositiveRate estimates the current false positive rate from the number
+// of keys added, (1 - e^(-kn/m))^k. It grows past the rate the filter was
+// sized for once more keys than planned are added.
+func (f *Filter) FalsePositiveRate() float64 {
+	return math.Pow(1-math.Exp(-float64(f.k)*float64(f.Len())/float64(f.m)), float64(f.k))
+}
@@ -0,0 +1,58 @@
+package bloom
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	f := New(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		f.Add("key" + strconv.Itoa(i))
+	}
+	for i := 0; i < 10000; i++ {
+		if !f.Test("key" + strconv.Itoa(i)) {
+			t.Fatalf("added key%d should test true", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if f.Test("other" + strconv.Itoa(i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / 10000; rate > 0.02 {
+		t.Fatalf("false positive rate %.4f is too high for a filter sized for 0.01", rate)
+	}
+	if rate := f.FalsePositiveRate(); rate < 0.005 || rate > 0.015 {
+		t.Fatalf("estimated false positive rate %.4f should be close to 0.01", rate)
+	}
+}
+
+func TestConcurrentAdd(t *testing.T) {
+	f := New(1000, 0.01)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 250; i++ {
+				key := strconv.Itoa(w*250 + i)
+				f.Add(key)
+				f.Test(key)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if f.Len() != 1000 {
+		t.Fatalf("filter should count 1000 keys, got %d", f.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		if !f.Test(strconv.Itoa(i)) {
+			t.Fatalf("key %d added concurrently should test true", i)
+		}
+	}
+}
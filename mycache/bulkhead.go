@@ -0,0 +1,160 @@
+package mycache
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultLoadQueueTimeout = 5 * time.Second
+
+// ErrOverloaded is returned, wrapped, when a load is rejected to protect the
+// origin, see SetLoadLimit.
+var ErrOverloaded = errors.New("mycache: overloaded")
+
+// LoadLimitOptions configure the limit on concurrent loads from the Getter
+// of a group, see SetLoadLimit.
+type LoadLimitOptions struct {
+	// MaxConcurrent is the number of Getter calls run at once.
+	MaxConcurrent int
+	// MaxQueue caps the loads waiting for their turn, further loads fail
+	// right away. Zero means no cap.
+	MaxQueue int
+	// QueueTimeout is the longest a load waits for its turn, it defaults
+	// to five seconds.
+	QueueTimeout time.Duration
+	// Priority, if set, orders the waiting loads: those of keys with a
+	// higher priority run first, equal ones in the order they came in.
+	Priority func(key string) int
+}
+
+// SetLoadLimit caps the concurrent calls of the group's Getter, so that a
+// cold cache asked for many distinct keys doesn't overwhelm the backing
+// store. Loads past the cap wait in a queue, and fail with ErrOverloaded if
+// the queue is full or they time out. It must be called before the group
+// is used. A MaxConcurrent of zero, the default, disables the limit.
+func (g *Group) SetLoadLimit(opts LoadLimitOptions) {
+	if opts.MaxConcurrent <= 0 {
+		g.bulkhead = nil
+		return
+	}
+	if opts.QueueTimeout <= 0 {
+		opts.QueueTimeout = defaultLoadQueueTimeout
+	}
+	g.bulkhead = &bulkhead{LoadLimitOptions: opts, stats: &g.Stats}
+}
+
+// bulkhead implements LoadLimitOptions.
+type bulkhead struct {
+	LoadLimitOptions
+	stats *Stats
+
+	mu      sync.Mutex
+	running int
+	waiting loadQueue
+	// seq numbers the waiting loads in order of arrival.
+	seq uint64
+}
+
+// acquire waits for the turn of a load of key. On success the load must
+// call release once done.
+func (b *bulkhead) acquire(key string) error {
+	b.mu.Lock()
+	if b.running < b.MaxConcurrent && len(b.waiting) == 0 {
+		b.running++
+		b.mu.Unlock()
+		return nil
+	}
+	if b.MaxQueue > 0 && len(b.waiting) >= b.MaxQueue {
+		b.mu.Unlock()
+		b.stats.LoadQueueRejects.Add(1)
+		return fmt.Errorf("%w: load queue is full", ErrOverloaded)
+	}
+	w := &loadWaiter{seq: b.seq, ready: make(chan struct{})}
+	if b.Priority != nil {
+		w.priority = b.Priority(key)
+	}
+	b.seq++
+	heap.Push(&b.waiting, w)
+	b.mu.Unlock()
+	b.stats.LoadQueueDepth.Add(1)
+	defer b.stats.LoadQueueDepth.Add(-1)
+
+	start := time.Now()
+	defer func() {
+		b.stats.LoadQueueWaits.Add(1)
+		b.stats.LoadQueueWaitTime.Add(int64(time.Since(start)))
+	}()
+	timer := time.NewTimer(b.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		return nil
+	case <-timer.C:
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if w.index < 0 {
+		// released to just as the timer fired
+		return nil
+	}
+	heap.Remove(&b.waiting, w.index)
+	b.stats.LoadQueueRejects.Add(1)
+	return fmt.Errorf("%w: load waited for %v", ErrOverloaded, b.QueueTimeout)
+}
+
+// release ends a load, handing its turn to the first waiting load.
+func (b *bulkhead) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.waiting) == 0 {
+		b.running--
+		return
+	}
+	w := heap.Pop(&b.waiting).(*loadWaiter)
+	close(w.ready)
+}
+
+// loadWaiter is a load waiting for its turn.
+type loadWaiter struct {
+	priority int
+	seq      uint64
+	ready    chan struct{}
+	// index is the position in the loadQueue, -1 once popped.
+	index int
+}
+
+// loadQueue is a heap of waiting loads, highest priority first.
+type loadQueue []*loadWaiter
+
+func (q loadQueue) Len() int { return len(q) }
+
+func (q loadQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q loadQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *loadQueue) Push(x interface{}) {
+	w := x.(*loadWaiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *loadQueue) Pop() interface{} {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*q = old[:len(old)-1]
+	return w
+}
@@ -1,23 +1,172 @@
 package mycache
 
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
 // A ByteView holds an immutable view of bytes.
+// Internally it wraps either a []byte or a string,
+// but that detail is invisible to callers.
 type ByteView struct {
+	// If b is non-nil, b is used, else s is used.
 	b []byte
+	s string
+	// e is the time the view expires at, the zero value never expires.
+	e time.Time
+	// z, if set, compressed b. Compressed views only live in the cache,
+	// Group.Get returns uncompressed ones.
+	z Compressor
 }
 
 // Len returns the view's length
 func (v ByteView) Len() int {
-	return len(v.b)
+	if v.b != nil {
+		return len(v.b)
+	}
+	return len(v.s)
+}
+
+// Expire returns the time the view expires at.
+// The zero time means the view never expires.
+func (v ByteView) Expire() time.Time {
+	return v.e
+}
+
+// expired reports whether the view has expired at now.
+func (v ByteView) expired(now time.Time) bool {
+	return !v.e.IsZero() && !now.Before(v.e)
+}
+
+// same reports whether v and w view the same data with the same expiry,
+// without comparing the bytes of views backed by a []byte.
+func (v ByteView) same(w ByteView) bool {
+	if v.Len() != w.Len() || !v.e.Equal(w.e) {
+		return false
+	}
+	if v.b == nil || w.b == nil {
+		return v.b == nil && w.b == nil && v.s == w.s
+	}
+	return len(v.b) == 0 || &v.b[0] == &w.b[0]
+}
+
+// data returns the data as a byte slice, without copying it if v is backed
+// by a []byte. The slice must not be modified.
+func (v ByteView) data() []byte {
+	if v.b != nil {
+		return v.b
+	}
+	return []byte(v.s)
 }
 
 // ByteSlice returns a copy of the data as a byte slice.
 func (v ByteView) ByteSlice() []byte {
-	return cloneBytes(v.b)
+	if v.b != nil {
+		return cloneBytes(v.b)
+	}
+	return []byte(v.s)
 }
 
 // String returns the data as a string, making a copy if necessary.
 func (v ByteView) String() string {
-	return string(v.b)
+	if v.b != nil {
+		return string(v.b)
+	}
+	return v.s
+}
+
+// At returns the byte at index i.
+func (v ByteView) At(i int) byte {
+	if v.b != nil {
+		return v.b[i]
+	}
+	return v.s[i]
+}
+
+// Slice slices the view between the provided from and to indices.
+func (v ByteView) Slice(from, to int) ByteView {
+	if v.b != nil {
+		return ByteView{b: v.b[from:to], e: v.e}
+	}
+	return ByteView{s: v.s[from:to], e: v.e}
+}
+
+// SliceFrom slices the view from the provided index until the end.
+func (v ByteView) SliceFrom(from int) ByteView {
+	return v.Slice(from, v.Len())
+}
+
+// Copy copies b into dest and returns the number of bytes copied.
+func (v ByteView) Copy(dest []byte) int {
+	if v.b != nil {
+		return copy(dest, v.b)
+	}
+	return copy(dest, v.s)
+}
+
+// Equal returns whether the bytes in v are the same as the bytes in v2.
+func (v ByteView) Equal(v2 ByteView) bool {
+	if v2.b == nil {
+		return v.EqualString(v2.s)
+	}
+	return v.EqualBytes(v2.b)
+}
+
+// EqualString returns whether the bytes in v are the same as the bytes in s.
+func (v ByteView) EqualString(s string) bool {
+	if v.b == nil {
+		return v.s == s
+	}
+	return string(v.b) == s
+}
+
+// EqualBytes returns whether the bytes in v are the same as the bytes in b2.
+func (v ByteView) EqualBytes(b2 []byte) bool {
+	if v.b != nil {
+		return bytes.Equal(v.b, b2)
+	}
+	return v.s == string(b2)
+}
+
+// Reader returns an io.ReadSeeker for the bytes in v.
+func (v ByteView) Reader() io.ReadSeeker {
+	if v.b != nil {
+		return bytes.NewReader(v.b)
+	}
+	return strings.NewReader(v.s)
+}
+
+// ReadAt implements io.ReaderAt on the bytes in v.
+func (v ByteView) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("view: invalid offset")
+	}
+	if off >= int64(v.Len()) {
+		return 0, io.EOF
+	}
+	n = v.SliceFrom(int(off)).Copy(p)
+	if n < len(p) {
+		err = io.EOF
+	}
+	return
+}
+
+// WriteTo implements io.WriterTo on the bytes in v.
+func (v ByteView) WriteTo(w io.Writer) (n int64, err error) {
+	var m int
+	if v.b != nil {
+		m, err = w.Write(v.b)
+	} else {
+		m, err = io.WriteString(w, v.s)
+	}
+	if err == nil && m < v.Len() {
+		err = io.ErrShortWrite
+	}
+	n = int64(m)
+	return
 }
 
 func cloneBytes(b []byte) []byte {
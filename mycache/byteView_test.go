@@ -0,0 +1,116 @@
+package mycache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func of(x string) [2]ByteView {
+	return [2]ByteView{{b: []byte(x)}, {s: x}}
+}
+
+func TestByteView(t *testing.T) {
+	for _, s := range []string{"", "x", "yy"} {
+		for _, v := range of(s) {
+			name := fmt.Sprintf("string %q, view %+v", s, v)
+			if v.Len() != len(s) {
+				t.Errorf("%s: Len = %d; want %d", name, v.Len(), len(s))
+			}
+			if v.String() != s {
+				t.Errorf("%s: String = %q; want %q", name, v.String(), s)
+			}
+			var longDest [3]byte
+			if n := v.Copy(longDest[:]); n != len(s) {
+				t.Errorf("%s: long Copy = %d; want %d", name, n, len(s))
+			}
+			var shortDest [1]byte
+			if n := v.Copy(shortDest[:]); n != min(len(s), 1) {
+				t.Errorf("%s: short Copy = %d; want %d", name, n, min(len(s), 1))
+			}
+			if got, err := io.ReadAll(v.Reader()); err != nil || string(got) != s {
+				t.Errorf("%s: Reader = %q, %v; want %q", name, got, err, s)
+			}
+			if got, err := io.ReadAll(io.NewSectionReader(v, 0, int64(len(s)))); err != nil || string(got) != s {
+				t.Errorf("%s: SectionReader of ReaderAt = %q, %v; want %q", name, got, err, s)
+			}
+			var buf bytes.Buffer
+			if n, err := v.WriteTo(&buf); err != nil || n != int64(len(s)) || buf.String() != s {
+				t.Errorf("%s: WriteTo = %d, %v, wrote %q; want %q", name, n, err, buf.String(), s)
+			}
+		}
+	}
+}
+
+func TestByteViewEqual(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want bool
+	}{
+		{"x", "x", true},
+		{"x", "y", false},
+		{"x", "yy", false},
+		{"", "", true},
+	}
+	for i, tt := range tests {
+		for _, va := range of(tt.a) {
+			if va.EqualString(tt.b) != tt.want {
+				t.Errorf("%d. EqualString(%q) = %v; want %v", i, tt.b, !tt.want, tt.want)
+			}
+			if va.EqualBytes([]byte(tt.b)) != tt.want {
+				t.Errorf("%d. EqualBytes(%q) = %v; want %v", i, tt.b, !tt.want, tt.want)
+			}
+			for _, vb := range of(tt.b) {
+				if va.Equal(vb) != tt.want {
+					t.Errorf("%d. %+v Equal(%+v) = %v; want %v", i, va, vb, !tt.want, tt.want)
+				}
+			}
+		}
+	}
+}
+
+func TestByteViewSlice(t *testing.T) {
+	tests := []struct {
+		in   string
+		from int
+		to   interface{} // nil to mean the end (SliceFrom); else int
+		want string
+	}{
+		{in: "abc", from: 1, to: 2, want: "b"},
+		{in: "abc", from: 1, want: "bc"},
+		{in: "abc", to: 2, want: "ab"},
+	}
+	for i, tt := range tests {
+		for _, v := range of(tt.in) {
+			var got ByteView
+			if tt.to == nil {
+				got = v.SliceFrom(tt.from)
+			} else {
+				got = v.Slice(tt.from, tt.to.(int))
+			}
+			if !got.EqualString(tt.want) {
+				t.Errorf("%d. got %q; want %q", i, got.String(), tt.want)
+			}
+			if got.At(0) != tt.want[0] {
+				t.Errorf("%d. At(0) = %q; want %q", i, got.At(0), tt.want[0])
+			}
+		}
+	}
+}
+
+func TestStringGetter(t *testing.T) {
+	g := newTestGroup(t, "stringGetterGroup", 2<<10, StringGetterFunc(func(key string) (string, error) {
+		return "value of " + key, nil
+	}))
+	for i := 0; i < 2; i++ {
+		v, err := g.Get("Tom")
+		if err != nil || !v.EqualString("value of Tom") {
+			t.Fatalf("Get = %q, %v", v.String(), err)
+		}
+		if v.b != nil {
+			t.Fatalf("value of a StringGetterFunc should be string-backed")
+		}
+	}
+}
@@ -1,34 +1,308 @@
 package mycache
 
 import (
+	"log"
+	"mycache/diskcache"
 	"mycache/lru"
 	"sync"
+	"time"
 )
 
 type cache struct {
 	mu         sync.Mutex
-	lru        *lru.Cache
+	lru        *lru.TypedCache[string, ByteView]
 	cacheBytes int64
+	// disk, if set, is the second tier that entries evicted from lru are demoted to.
+	disk *diskcache.Cache
+	// evicted collects the entries leaving lru during the current call,
+	// they are demoted to disk or reported to onEvict once mu is released.
+	evicted []eviction
+	// reason is why the entries lru drops leave the cache, set with mu held.
+	reason EvictReason
+	// onEvict, if set, is told about the values leaving the cache.
+	onEvict func(key string, value ByteView, reason EvictReason)
+	// maxStale is how long expired values are kept to be served stale.
+	maxStale time.Duration
+	// compressor, if set, compresses values of at least minCompress bytes,
+	// counting the compressed bytes in stats.
+	compressor  Compressor
+	minCompress int
+	stats       *Stats
+	// closed is set by close, the cache then stays empty.
+	closed bool
 }
 
-func (c *cache) add(key string, value ByteView) {
+// eviction is an entry leaving the cache and why.
+type eviction struct {
+	entry
+	reason EvictReason
+}
+
+// add stores value, compressing it if enabled, and returns the stored view.
+func (c *cache) add(key string, value ByteView) ByteView {
+	value = c.compress(value)
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	if c.closed {
+		c.mu.Unlock()
+		return value
+	}
 	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil)
+		c.lru = lru.NewTypedCache(c.cacheBytes, entrySize[ByteView], c.onEvicted)
+	}
+	if c.onEvict != nil {
+		if old, ok := c.lru.Get(key); ok {
+			c.evicted = append(c.evicted, eviction{entry{key, old}, EvictInvalidated})
+		}
 	}
+	c.reason = EvictCapacity
 	c.lru.Add(key, value)
+	evicted := c.takeEvicted()
+	c.mu.Unlock()
+
+	c.release(evicted)
+	return value
 }
 
-func (c *cache) get(key string) (valve ByteView, ok bool) {
+// onEvicted is called by lru with mu held.
+func (c *cache) onEvicted(key string, v ByteView) {
+	demote := c.reason == EvictCapacity && c.disk != nil && !v.expired(time.Now())
+	if demote || c.onEvict != nil {
+		c.evicted = append(c.evicted, eviction{entry{key, v}, c.reason})
+	}
+}
+
+// takeEvicted returns and resets the collected evictions, with mu held.
+func (c *cache) takeEvicted() []eviction {
+	evicted := c.evicted
+	c.evicted = nil
+	return evicted
+}
+
+// release demotes the entries evicted for capacity to disk and reports
+// those leaving the cache to onEvict, it must be called without mu held.
+func (c *cache) release(evicted []eviction) {
+	for _, e := range evicted {
+		v, err := e.value.decompress()
+		if err != nil {
+			log.Println("[GeeCache] dropping evicted value of", e.key, err)
+			if c.stats != nil {
+				c.stats.DecompressErrs.Add(1)
+			}
+			continue
+		}
+		if e.reason == EvictCapacity && c.disk != nil && !v.expired(time.Now()) {
+			if c.disk.Put(e.key, v.data(), v.e) == nil {
+				continue
+			}
+		}
+		if c.onEvict != nil {
+			c.onEvict(e.key, v, e.reason)
+		}
+	}
+}
+
+// entrySize sizes cache entries by the length of their key and value.
+func entrySize[V lru.Value](key string, v V) int64 {
+	return int64(len(key)) + int64(v.Len())
+}
+
+// get returns the stored value for key, looking in the disk tier on a memory miss.
+// Values found on disk are promoted back to memory.
+// Values expired for less than maxStale are returned as is, callers check
+// whether they are stale. Older ones are removed and reported as a miss.
+func (c *cache) get(key string) (value ByteView, ok bool) {
+	if value, ok = c.getMemory(key); ok || c.disk == nil {
+		return
+	}
+	b, expire, ok := c.disk.Get(key)
+	if !ok {
+		return ByteView{}, false
+	}
+	c.disk.Remove(key)
+	return c.add(key, ByteView{b: b, e: expire}), true
+}
+
+// remove drops key from memory and from the disk tier.
+func (c *cache) remove(key string) {
+	c.mu.Lock()
+	if c.lru != nil {
+		c.reason = EvictRemoved
+		c.lru.Remove(key)
+	}
+	evicted := c.takeEvicted()
+	c.mu.Unlock()
+	if c.disk != nil {
+		c.disk.Remove(key)
+	}
+	c.release(evicted)
+}
+
+// close drops every value and closes the disk tier, which keeps its files.
+// Values added afterwards, by loads still in flight, are dropped too.
+func (c *cache) close() {
+	c.mu.Lock()
+	c.closed = true
+	evicted := c.takeEvicted()
+	if c.lru != nil && c.onEvict != nil {
+		c.lru.Range(func(key string, v ByteView) bool {
+			evicted = append(evicted, eviction{entry{key, v}, EvictInvalidated})
+			return true
+		})
+	}
+	c.lru = nil
+	c.mu.Unlock()
+	if c.disk != nil {
+		c.disk.Close()
+	}
+	c.release(evicted)
+}
+
+func (c *cache) getMemory(key string) (value ByteView, ok bool) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
 	if c.lru == nil {
+		c.mu.Unlock()
 		return
 	}
 
 	if v, ok := c.lru.Get(key); ok {
-		return v.(ByteView), ok
+		if !v.expired(time.Now().Add(-c.maxStale)) {
+			c.mu.Unlock()
+			return v, ok
+		}
+		c.reason = EvictExpired
+		c.lru.Remove(key)
 	}
+	evicted := c.takeEvicted()
+	c.mu.Unlock()
+	c.release(evicted)
 	return
 }
+
+// entry is a key and its cached value.
+type entry struct {
+	key   string
+	value ByteView
+}
+
+// hottest returns up to n unexpired entries as stored, most recently used first.
+func (c *cache) hottest(n int) []entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil || n <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	entries := make([]entry, 0, min(n, c.lru.Len()))
+	c.lru.Range(func(key string, v ByteView) bool {
+		if !v.expired(now) {
+			entries = append(entries, entry{key, v})
+		}
+		return len(entries) < n
+	})
+	return entries
+}
+
+const defaultNegativeCacheBytes = 1 << 20
+
+// negativeCache remembers not-found errors for a short time so that
+// requests for missing keys don't all reach the Getter.
+type negativeCache struct {
+	mu     sync.Mutex
+	lru    *lru.TypedCache[string, negativeEntry]
+	closed bool
+}
+
+// negativeEntry is a cached error, it is sized by its message.
+type negativeEntry struct {
+	err    error
+	expire time.Time
+}
+
+func (e negativeEntry) Len() int {
+	return len(e.err.Error())
+}
+
+func (c *negativeCache) add(key string, err error, expire time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	if c.lru == nil {
+		c.lru = lru.NewTypedCache(defaultNegativeCacheBytes, entrySize[negativeEntry], nil)
+	}
+	c.lru.Add(key, negativeEntry{err, expire})
+}
+
+// get returns the cached error for key, dropping it once it has expired.
+func (c *negativeCache) get(key string) (error, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return nil, false
+	}
+	v, ok := c.lru.Get(key)
+	if !ok {
+		return nil, false
+	}
+	if time.Now().Before(v.expire) {
+		return v.err, true
+	}
+	c.lru.Remove(key)
+	return nil, false
+}
+
+func (c *negativeCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru != nil {
+		c.lru.Remove(key)
+	}
+}
+
+func (c *negativeCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru, c.closed = nil, true
+}
+
+// maxCountedHits bounds the number of keys a hitCounter counts the hits of.
+const maxCountedHits = 1 << 16
+
+// hitCounter counts the cache hits of the most recently hit keys.
+type hitCounter struct {
+	mu     sync.Mutex
+	lru    *lru.TypedCache[string, int]
+	closed bool
+}
+
+// hit counts a hit of key and returns its hits so far.
+func (c *hitCounter) hit(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 1
+	}
+	if c.lru == nil {
+		c.lru = lru.NewTypedCache[string, int](maxCountedHits, nil, nil)
+	}
+	n, _ := c.lru.Get(key)
+	c.lru.Add(key, n+1)
+	return n + 1
+}
+
+// reset forgets the hits of key, e.g. once a new value is loaded.
+func (c *hitCounter) reset(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru != nil {
+		c.lru.Remove(key)
+	}
+}
+
+func (c *hitCounter) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru, c.closed = nil, true
+}
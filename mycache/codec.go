@@ -0,0 +1,72 @@
+package mycache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"mycache/msgpack"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// A Codec converts values of type T to and from the bytes a Group caches
+// and sends to peers.
+type Codec[T any] interface {
+	Marshal(v T) ([]byte, error)
+	Unmarshal(data []byte) (T, error)
+}
+
+// JSONCodec encodes values with encoding/json.
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Marshal(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[T]) Unmarshal(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// GobCodec encodes values with encoding/gob.
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Marshal(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (GobCodec[T]) Unmarshal(data []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
+
+// ProtoCodec encodes protobuf messages, T is a pointer to a generated message type.
+type ProtoCodec[T proto.Message] struct{}
+
+func (ProtoCodec[T]) Marshal(v T) ([]byte, error) {
+	return proto.Marshal(v)
+}
+
+func (ProtoCodec[T]) Unmarshal(data []byte) (T, error) {
+	var zero T
+	v := proto.MessageReflect(zero).Type().New().Interface().(T)
+	err := proto.Unmarshal(data, v)
+	return v, err
+}
+
+// MsgpackCodec encodes values in the MessagePack binary format, see package msgpack.
+type MsgpackCodec[T any] struct{}
+
+func (MsgpackCodec[T]) Marshal(v T) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (MsgpackCodec[T]) Unmarshal(data []byte) (T, error) {
+	var v T
+	err := msgpack.Unmarshal(data, &v)
+	return v, err
+}
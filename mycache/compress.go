@@ -0,0 +1,137 @@
+package mycache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// A Compressor compresses the values a Group stores and sends to its peers,
+// see SetCompression.
+type Compressor interface {
+	// Name identifies the compression on the peer protocol, e.g. "gzip".
+	Name() string
+	Compress(b []byte) ([]byte, error)
+	Decompress(b []byte) ([]byte, error)
+}
+
+// Gzip compresses values with compress/gzip.
+var Gzip Compressor = gzipCompressor{}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{Gzip.Name(): Gzip}
+)
+
+// RegisterCompressor makes c available to decompress values received from
+// peers. Every peer of a group compressed with c must register it.
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[c.Name()] = c
+}
+
+// compressorFor returns the registered compressor called name, or nil.
+func compressorFor(name string) Compressor {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	return compressors[name]
+}
+
+// compressorNames returns the names of the registered compressors, they are
+// the encodings a pool accepts from its peers.
+func compressorNames() []string {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	names := make([]string, 0, len(compressors))
+	for name := range compressors {
+		names = append(names, name)
+	}
+	return names
+}
+
+type gzipCompressor struct{}
+
+var gzipWriters = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(nil) },
+}
+
+func (gzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (gzipCompressor) Compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(zw)
+	zw.Reset(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(b []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// decompress returns the uncompressed view of v.
+func (v ByteView) decompress() (ByteView, error) {
+	if v.z == nil {
+		return v, nil
+	}
+	b, err := v.z.Decompress(v.b)
+	if err != nil {
+		return ByteView{}, fmt.Errorf("decompressing %s value: %v", v.z.Name(), err)
+	}
+	return ByteView{b: b, e: v.e}, nil
+}
+
+// compress returns v compressed if compression is enabled, v is at least
+// minCompress bytes long and compressing it saves space.
+func (c *cache) compress(v ByteView) ByteView {
+	if c.compressor == nil || v.z != nil || v.Len() < c.minCompress {
+		return v
+	}
+	b, err := c.compressor.Compress(v.data())
+	if err != nil {
+		return v
+	}
+	if c.stats != nil {
+		c.stats.CompressIn.Add(int64(v.Len()))
+		c.stats.CompressOut.Add(int64(len(b)))
+	}
+	if len(b) >= v.Len() {
+		return v
+	}
+	return ByteView{b: b, e: v.e, z: c.compressor}
+}
+
+// received returns a value a peer sent with the given encoding in the form
+// the group stores it: still compressed if the group uses the same
+// compression, uncompressed otherwise.
+func (g *Group) received(v ByteView, encoding string) (ByteView, error) {
+	if encoding == "" {
+		return v, nil
+	}
+	if c := g.mainCache.compressor; c != nil && c.Name() == encoding {
+		v.z = c
+		return v, nil
+	}
+	c := compressorFor(encoding)
+	if c == nil {
+		return ByteView{}, fmt.Errorf("unknown encoding %q", encoding)
+	}
+	v.z = c
+	return v.decompress()
+}
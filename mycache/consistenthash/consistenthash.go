@@ -49,7 +49,7 @@ func (m *Map) Add(keys ...string) {
 
 // Get gets the closet item in hash to the provided key
 func (m *Map) Get(key string) string {
-	if len(key) == 0 {
+	if len(key) == 0 || len(m.keys) == 0 {
 		return ""
 	}
 
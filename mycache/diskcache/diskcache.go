@@ -0,0 +1,310 @@
+package diskcache
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Record layout, integers are big endian:
+//
+//	crc uint32 | flags uint8 | key length uint32 | value length uint32 | expire int64 | key | value
+//
+// crc is the CRC-32C of everything after it. expire is in unix nanoseconds, 0 never expires.
+const (
+	headerSize = 4 + 1 + 4 + 4 + 8
+	// flagTombstone marks a record that deletes its key.
+	flagTombstone = 1
+	logName       = "cache.log"
+	// minCompactBytes is the log size below which the log is never compacted.
+	minCompactBytes = 1 << 20
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Cache is a disk-backed cache: an append-only log of records with an
+// in-memory index of the live ones. When the live records exceed maxBytes
+// the oldest written ones are dropped, and the log is compacted once most
+// of it is garbage. It is safe for concurrent access.
+type Cache struct {
+	mu       sync.Mutex
+	dir      string
+	f        *os.File
+	maxBytes int64
+	// size is the length of the log, live the length of its live records.
+	size, live int64
+	// records holds the live records oldest written first, which is the
+	// order of their offsets, and index their elements by key.
+	records *list.List
+	index   map[string]*list.Element
+}
+
+// record is a live record and its key.
+type record struct {
+	key string
+	location
+}
+
+// location is where a live record is in the log.
+type location struct {
+	off    int64
+	key    int
+	value  int
+	expire int64
+}
+
+func (l location) len() int64 {
+	return int64(headerSize + l.key + l.value)
+}
+
+// Open opens the cache in dir, creating it if needed, and rebuilds the index from its log.
+// A torn or corrupt tail, e.g. after a crash, is cut off.
+// A maxBytes of zero means the cache is unbounded.
+func Open(dir string, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, logName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		dir:      dir,
+		f:        f,
+		maxBytes: maxBytes,
+		records:  list.New(),
+		index:    map[string]*list.Element{},
+	}
+	if err = c.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	c.evict()
+	return c, nil
+}
+
+// load scans the log, indexing its records and truncating it after the last valid one.
+func (c *Cache) load() error {
+	r := bufio.NewReader(io.NewSectionReader(c.f, 0, 1<<62))
+	var off int64
+	for {
+		loc, flags, key, err := readRecord(r, off)
+		if err != nil {
+			break
+		}
+		c.drop(key)
+		if flags&flagTombstone == 0 {
+			c.insert(key, loc)
+		}
+		off += loc.len()
+	}
+	c.size = off
+	return c.f.Truncate(off)
+}
+
+// readRecord reads the record at off and checks its checksum.
+func readRecord(r io.Reader, off int64) (loc location, flags byte, key string, err error) {
+	var h [headerSize]byte
+	if _, err = io.ReadFull(r, h[:]); err != nil {
+		return
+	}
+	loc = location{
+		off:    off,
+		key:    int(binary.BigEndian.Uint32(h[5:9])),
+		value:  int(binary.BigEndian.Uint32(h[9:13])),
+		expire: int64(binary.BigEndian.Uint64(h[13:21])),
+	}
+	crc := crc32.Update(0, castagnoli, h[4:])
+	body, err := io.ReadAll(io.LimitReader(r, int64(loc.key+loc.value)))
+	if err != nil {
+		return
+	}
+	if len(body) < loc.key+loc.value {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+	if crc32.Update(crc, castagnoli, body) != binary.BigEndian.Uint32(h[:4]) {
+		err = errors.New("diskcache: checksum mismatch")
+		return
+	}
+	return loc, h[4], string(body[:loc.key]), nil
+}
+
+// Put stores value for key, replacing any previous value.
+func (c *Cache) Put(key string, value []byte, expire time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var e int64
+	if !expire.IsZero() {
+		e = expire.UnixNano()
+	}
+	loc, err := c.append(0, key, value, e)
+	if err != nil {
+		return err
+	}
+	c.drop(key)
+	c.insert(key, loc)
+	c.evict()
+	return c.maybeCompact()
+}
+
+// Get returns the value stored for key. Expired values are removed and reported as missing.
+func (c *Cache) Get(key string) (value []byte, expire time.Time, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.index[key]
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	loc := e.Value.(*record).location
+	if loc.expire != 0 {
+		expire = time.Unix(0, loc.expire)
+		if !time.Now().Before(expire) {
+			c.remove(key)
+			return nil, time.Time{}, false
+		}
+	}
+	buf := make([]byte, loc.value)
+	if _, err := c.f.ReadAt(buf, loc.off+int64(headerSize+loc.key)); err != nil {
+		c.drop(key)
+		return nil, time.Time{}, false
+	}
+	return buf, expire, true
+}
+
+// Remove deletes the value stored for key, if any.
+func (c *Cache) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(key)
+}
+
+func (c *Cache) remove(key string) {
+	if _, ok := c.index[key]; !ok {
+		return
+	}
+	c.drop(key)
+	// the tombstone keeps the key from coming back when the log is reopened,
+	// if it can't be written the key may come back, which is harmless for a cache
+	c.append(flagTombstone, key, nil, 0)
+}
+
+// insert indexes the record of key at loc, which must be the last written.
+func (c *Cache) insert(key string, loc location) {
+	c.index[key] = c.records.PushBack(&record{key, loc})
+	c.live += loc.len()
+}
+
+// drop removes key from the index.
+func (c *Cache) drop(key string) {
+	if e, ok := c.index[key]; ok {
+		c.live -= e.Value.(*record).len()
+		c.records.Remove(e)
+		delete(c.index, key)
+	}
+}
+
+// Len returns the number of live entries.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.index)
+}
+
+// Bytes returns the size of the live entries.
+func (c *Cache) Bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.live
+}
+
+// Close closes the log.
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.f.Close()
+}
+
+func (c *Cache) append(flags byte, key string, value []byte, expire int64) (location, error) {
+	loc := location{off: c.size, key: len(key), value: len(value), expire: expire}
+	buf := make([]byte, loc.len())
+	buf[4] = flags
+	binary.BigEndian.PutUint32(buf[5:9], uint32(len(key)))
+	binary.BigEndian.PutUint32(buf[9:13], uint32(len(value)))
+	binary.BigEndian.PutUint64(buf[13:21], uint64(expire))
+	copy(buf[headerSize:], key)
+	copy(buf[headerSize+len(key):], value)
+	binary.BigEndian.PutUint32(buf[:4], crc32.Checksum(buf[4:], castagnoli))
+	if _, err := c.f.WriteAt(buf, c.size); err != nil {
+		return location{}, err
+	}
+	c.size += loc.len()
+	return loc, nil
+}
+
+// evict drops the oldest written records until the live ones fit in maxBytes.
+// Their space is reclaimed by the next compaction.
+func (c *Cache) evict() {
+	if c.maxBytes <= 0 {
+		return
+	}
+	for c.live > c.maxBytes && c.records.Len() > 0 {
+		c.drop(c.records.Front().Value.(*record).key)
+	}
+}
+
+// maybeCompact compacts the log once more than half of it is garbage.
+func (c *Cache) maybeCompact() error {
+	if c.size < minCompactBytes || c.size < 2*c.live {
+		return nil
+	}
+	return c.compact()
+}
+
+// compact rewrites the live records to a new log and swaps it in.
+func (c *Cache) compact() error {
+	path := filepath.Join(c.dir, logName)
+	f, err := os.OpenFile(path+".compact", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	// the records keep their order, their new offsets are set once the new log is in place
+	offs := make([]int64, 0, c.records.Len())
+	var off int64
+	for e := c.records.Front(); e != nil; e = e.Next() {
+		r := e.Value.(*record)
+		buf := make([]byte, r.len())
+		if _, err = c.f.ReadAt(buf, r.off); err == nil {
+			_, err = f.WriteAt(buf, off)
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+		offs = append(offs, off)
+		off += r.len()
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		f.Close()
+		return err
+	}
+	c.f.Close()
+	c.f, c.size = f, off
+	for e := c.records.Front(); e != nil; e = e.Next() {
+		e.Value.(*record).off, offs = offs[0], offs[1:]
+	}
+	return nil
+}
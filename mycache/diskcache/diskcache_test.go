@@ -0,0 +1,124 @@
+package diskcache
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPutGet(t *testing.T) {
+	c, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Put("key1", []byte("12345"), time.Time{})
+	c.Put("key1", []byte("67890"), time.Time{})
+	if v, _, ok := c.Get("key1"); !ok || string(v) != "67890" {
+		t.Fatalf("cache hit key1=67890 failed")
+	}
+	if _, _, ok := c.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+
+	c.Put("key2", []byte("x"), time.Now().Add(-time.Second))
+	if _, _, ok := c.Get("key2"); ok {
+		t.Fatalf("expired key2 should be missing")
+	}
+	c.Remove("key1")
+	if _, _, ok := c.Get("key1"); ok || c.Len() != 0 {
+		t.Fatalf("removed key1 should be missing")
+	}
+}
+
+func TestEvict(t *testing.T) {
+	// each record takes headerSize+4+1 bytes
+	c, err := Open(t.TempDir(), 3*(headerSize+5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 5; i++ {
+		c.Put("key"+strconv.Itoa(i), []byte("v"), time.Time{})
+	}
+	if c.Len() != 3 {
+		t.Fatalf("cache should hold 3 entries, got %d", c.Len())
+	}
+	for i := 0; i < 5; i++ {
+		if _, _, ok := c.Get("key" + strconv.Itoa(i)); ok != (i >= 2) {
+			t.Errorf("key%d: expected hit %v", i, i >= 2)
+		}
+	}
+
+	// a rewritten key is the most recently written
+	c.Put("key2", []byte("w"), time.Time{})
+	c.Put("key5", []byte("v"), time.Time{})
+	if _, _, ok := c.Get("key3"); ok {
+		t.Errorf("key3 should be evicted as the oldest written")
+	}
+	if v, _, ok := c.Get("key2"); !ok || string(v) != "w" {
+		t.Errorf("rewritten key2 should be kept")
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put("key1", []byte("12345"), time.Time{})
+	c.Put("key2", []byte("67890"), time.Time{})
+	c.Put("key3", []byte("abcde"), time.Time{})
+	c.Remove("key2")
+	c.Close()
+
+	// a torn write at the end of the log is cut off
+	f, _ := os.OpenFile(filepath.Join(dir, logName), os.O_WRONLY|os.O_APPEND, 0)
+	f.Write([]byte{1, 2, 3, 4, 5, 6, 7})
+	f.Close()
+
+	c, err = Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if v, _, ok := c.Get("key1"); !ok || string(v) != "12345" {
+		t.Fatalf("key1 should survive a reopen")
+	}
+	if _, _, ok := c.Get("key2"); ok {
+		t.Fatalf("removed key2 should stay removed after a reopen")
+	}
+	c.Put("key4", []byte("fghij"), time.Time{})
+	if v, _, ok := c.Get("key4"); !ok || string(v) != "fghij" {
+		t.Fatalf("key4 should be readable after the torn tail")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	value := make([]byte, 64<<10)
+	for i := 0; i < 64; i++ {
+		c.Put("key", value, time.Time{})
+	}
+	c.Put("other", []byte("v"), time.Time{})
+	if c.size >= minCompactBytes && c.size >= 2*c.live {
+		t.Fatalf("log should have been compacted, size %d live %d", c.size, c.live)
+	}
+	if v, _, ok := c.Get("key"); !ok || len(v) != len(value) {
+		t.Fatalf("key should survive compaction")
+	}
+	if v, _, ok := c.Get("other"); !ok || string(v) != "v" {
+		t.Fatalf("other should survive compaction")
+	}
+}
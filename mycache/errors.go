@@ -0,0 +1,135 @@
+package mycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "mycache/mycachepb"
+	"net"
+	"net/http"
+)
+
+// ErrNotFound is returned, possibly wrapped, by a Getter when the key does
+// not exist. Groups with a negative TTL cache such errors.
+var ErrNotFound = errors.New("mycache: not found")
+
+// ErrNoSuchGroup is returned, wrapped, when a peer has no group of the requested name.
+var ErrNoSuchGroup = errors.New("mycache: no such group")
+
+// ErrGroupExists is returned, wrapped, by NewGroup when a group of the same
+// name is already registered.
+var ErrGroupExists = errors.New("mycache: group exists")
+
+// ErrPeerUnavailable is returned, wrapped, when a peer can't be reached or
+// is shutting down.
+var ErrPeerUnavailable = errors.New("mycache: peer unavailable")
+
+// ErrTimeout is returned, wrapped, when a request to a peer times out.
+var ErrTimeout = errors.New("mycache: timeout")
+
+// ErrUnauthenticated is returned, wrapped, when a peer rejects a request for
+// lacking valid credentials.
+var ErrUnauthenticated = errors.New("mycache: unauthenticated")
+
+// ErrForbidden is returned, wrapped, when a peer rejects a request the
+// caller is not allowed to make.
+var ErrForbidden = errors.New("mycache: forbidden")
+
+// errBadRequest marks requests a peer could not make sense of.
+var errBadRequest = errors.New("mycache: bad request")
+
+// IsNotFound reports whether err marks a key that does not exist: it wraps
+// ErrNotFound, or it implements NotFound() bool and that returns true.
+func IsNotFound(err error) bool {
+	if errors.Is(err, ErrNotFound) {
+		return true
+	}
+	var nf interface{ NotFound() bool }
+	return errors.As(err, &nf) && nf.NotFound()
+}
+
+// A PeerError is an error returned by a peer.
+// It matches the sentinel error of its code with errors.Is.
+type PeerError struct {
+	Code    pb.Code
+	Message string
+	// Retryable reports whether the request may succeed if sent again.
+	Retryable bool
+}
+
+func (e *PeerError) Error() string {
+	return e.Message
+}
+
+func (e *PeerError) Is(target error) bool {
+	return target != nil && codeErrors[e.Code] == target
+}
+
+// codeErrors are the sentinel errors of the error codes.
+var codeErrors = map[pb.Code]error{
+	pb.Code_NOT_FOUND:       ErrNotFound,
+	pb.Code_NO_SUCH_GROUP:   ErrNoSuchGroup,
+	pb.Code_BAD_REQUEST:     errBadRequest,
+	pb.Code_UNAUTHENTICATED: ErrUnauthenticated,
+	pb.Code_FORBIDDEN:       ErrForbidden,
+	pb.Code_UNAVAILABLE:     ErrPeerUnavailable,
+	pb.Code_TIMEOUT:         ErrTimeout,
+}
+
+// codeStatus are the HTTP statuses of the error codes.
+var codeStatus = map[pb.Code]int{
+	pb.Code_UNKNOWN:         http.StatusInternalServerError,
+	pb.Code_NOT_FOUND:       http.StatusNotFound,
+	pb.Code_NO_SUCH_GROUP:   http.StatusNotFound,
+	pb.Code_BAD_REQUEST:     http.StatusBadRequest,
+	pb.Code_UNAUTHENTICATED: http.StatusUnauthorized,
+	pb.Code_FORBIDDEN:       http.StatusForbidden,
+	pb.Code_UNAVAILABLE:     http.StatusServiceUnavailable,
+	pb.Code_TIMEOUT:         http.StatusGatewayTimeout,
+	pb.Code_INTERNAL:        http.StatusInternalServerError,
+}
+
+// toPB returns the error sent to peers for err.
+func toPB(err error) *pb.Error {
+	e := &pb.Error{Code: pb.Code_INTERNAL, Message: err.Error()}
+	var pe *PeerError
+	switch {
+	case IsNotFound(err):
+		e.Code = pb.Code_NOT_FOUND
+	case errors.As(err, &pe):
+		// a peer's error passed on, e.g. by a load that failed on the owner
+		e.Code, e.Retryable = pe.Code, pe.Retryable
+	case errors.Is(err, ErrOverloaded):
+		// the requester had better load the key itself than retry
+		e.Code = pb.Code_UNAVAILABLE
+	case errors.Is(err, ErrTimeout) || errors.Is(err, context.DeadlineExceeded):
+		e.Code, e.Retryable = pb.Code_TIMEOUT, true
+	default:
+		for code, sentinel := range codeErrors {
+			if errors.Is(err, sentinel) {
+				e.Code = code
+				break
+			}
+		}
+		e.Retryable = e.Code == pb.Code_UNAVAILABLE
+	}
+	return e
+}
+
+// fromPB returns the error of a peer.
+func fromPB(e *pb.Error) error {
+	return &PeerError{Code: e.GetCode(), Message: e.GetMessage(), Retryable: e.GetRetryable()}
+}
+
+// transportError returns the error for a request that failed to reach a peer,
+// wrapping ErrTimeout or ErrPeerUnavailable.
+func transportError(err error) error {
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &ne) && ne.Timeout() {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrPeerUnavailable, err)
+}
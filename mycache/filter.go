@@ -0,0 +1,93 @@
+package mycache
+
+import (
+	"fmt"
+	"log"
+	"mycache/bloom"
+	"time"
+)
+
+// errFiltered is returned for keys the key filter rejects.
+var errFiltered = fmt.Errorf("%w: rejected by key filter", ErrNotFound)
+
+// A KeySource lists every valid key of a group by calling add for each one.
+// It feeds the group's key filter.
+type KeySource func(add func(key string)) error
+
+// FilterStats describes the key filter of a group.
+type FilterStats struct {
+	// Keys is the number of keys in the filter and Bits its size.
+	Keys, Bits uint64
+	// FalsePositiveRate is the rate estimated from Keys and Bits.
+	FalsePositiveRate float64
+	// Built is when the filter was last built.
+	Built time.Time
+}
+
+// keyFilter is a built filter and when it was built.
+type keyFilter struct {
+	*bloom.Filter
+	built time.Time
+}
+
+// EnableKeyFilter guards the group against requests for keys that don't
+// exist: Get rejects keys missing from a Bloom filter of the keys listed by
+// src before asking a peer or the Getter, with an error wrapping ErrNotFound.
+// The filter is sized for expected keys at a false positive rate of fpRate.
+// It is built before EnableKeyFilter returns, and rebuilt every interval if
+// interval is positive. Rebuilds run in the background and swap the new
+// filter in once complete, a failed rebuild keeps the old filter. They stop
+// when the group is deleted.
+func (g *Group) EnableKeyFilter(src KeySource, expected uint64, fpRate float64, interval time.Duration) error {
+	if err := g.RebuildKeyFilter(src, expected, fpRate); err != nil {
+		return err
+	}
+	if interval > 0 {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-g.done:
+					return
+				case <-ticker.C:
+				}
+				if err := g.RebuildKeyFilter(src, expected, fpRate); err != nil {
+					log.Printf("[GeeCache] rebuilding key filter of %s: %v", g.name, err)
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// RebuildKeyFilter builds a new key filter from src and swaps it in.
+// Gets keep using the old filter while the new one is built.
+func (g *Group) RebuildKeyFilter(src KeySource, expected uint64, fpRate float64) error {
+	f := bloom.New(expected, fpRate)
+	if err := src(f.Add); err != nil {
+		return fmt.Errorf("group %s: listing keys: %w", g.name, err)
+	}
+	g.filter.Store(&keyFilter{Filter: f, built: time.Now()})
+	return nil
+}
+
+// FilterStats describes the group's key filter, ok is false if there is none.
+func (g *Group) FilterStats() (stats FilterStats, ok bool) {
+	f := g.filter.Load()
+	if f == nil {
+		return FilterStats{}, false
+	}
+	return FilterStats{
+		Keys:              f.Len(),
+		Bits:              f.Bits(),
+		FalsePositiveRate: f.FalsePositiveRate(),
+		Built:             f.built,
+	}, true
+}
+
+// filtered reports whether the key filter rejects key.
+func (g *Group) filtered(key string) bool {
+	f := g.filter.Load()
+	return f != nil && !f.Test(key)
+}
@@ -1,7 +1,7 @@
 module mycache
 
-go 1.22.0
+go 1.24.0
 
 require github.com/golang/protobuf v1.5.4
 
-require google.golang.org/protobuf v1.36.6 // indirect
+require google.golang.org/protobuf v1.36.6
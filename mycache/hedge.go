@@ -0,0 +1,219 @@
+package mycache
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	pb "mycache/mycachepb"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultHedgePercentile = 0.95
+	defaultMaxHedgeDelay   = time.Second
+	defaultRetryBackoff    = 10 * time.Millisecond
+	defaultMaxRetryBackoff = time.Second
+
+	// latencyWindow is the number of recent peer latencies the hedging
+	// delay is computed from, and minLatencySamples the number needed
+	// before MaxDelay gives way to the percentile.
+	latencyWindow     = 256
+	minLatencySamples = 20
+	// latencyRecompute is how many samples are added between computations
+	// of the percentile.
+	latencyRecompute = 16
+)
+
+// HedgeOptions configure hedged loads from peers, see SetHedging.
+type HedgeOptions struct {
+	// Percentile of the recent latencies of successful peer loads after
+	// which a load is hedged, it defaults to 0.95.
+	Percentile float64
+	// MinDelay and MaxDelay bound the hedging delay. MaxDelay, which
+	// defaults to one second, is also the delay until enough latencies
+	// are known.
+	MinDelay, MaxDelay time.Duration
+}
+
+// RetryOptions configure retries of loads from peers, see SetRetries.
+type RetryOptions struct {
+	// MaxRetries is the number of times a failed load is retried, zero
+	// disables retries.
+	MaxRetries int
+	// Backoff bounds the random wait before the first retry, it defaults
+	// to 10ms. It doubles with every retry up to MaxBackoff, which
+	// defaults to one second.
+	Backoff, MaxBackoff time.Duration
+}
+
+// SetHedging makes loads from peers hedged: if the owner of a key hasn't
+// answered within a percentile of the latencies of recent peer loads, the
+// key is also loaded locally and the first load to succeed wins.
+// It must be called before the group is used.
+func (g *Group) SetHedging(opts HedgeOptions) {
+	if opts.Percentile <= 0 || opts.Percentile >= 1 {
+		opts.Percentile = defaultHedgePercentile
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = defaultMaxHedgeDelay
+	}
+	g.hedge = &hedger{HedgeOptions: opts}
+}
+
+// SetRetries makes loads from peers that fail with a retryable error, see
+// PeerError, retried after a jittered exponential backoff.
+// It must be called before the group is used.
+func (g *Group) SetRetries(opts RetryOptions) {
+	if opts.Backoff <= 0 {
+		opts.Backoff = defaultRetryBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaultMaxRetryBackoff
+	}
+	g.retry = opts
+}
+
+// hedger tracks the latencies of peer loads to compute the hedging delay.
+type hedger struct {
+	HedgeOptions
+
+	mu sync.Mutex
+	// latencies is a ring buffer of the last latencyWindow latencies.
+	latencies []time.Duration
+	next      int
+	added     int
+	// delay is the last computed percentile, zero until there are enough samples.
+	delay atomic.Int64
+}
+
+// observe records the latency of a successful peer load.
+func (h *hedger) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.latencies) < latencyWindow {
+		h.latencies = append(h.latencies, d)
+	} else {
+		h.latencies[h.next] = d
+		h.next = (h.next + 1) % latencyWindow
+	}
+	h.added++
+	if len(h.latencies) >= minLatencySamples && h.added%latencyRecompute == 0 {
+		sorted := slices.Clone(h.latencies)
+		slices.Sort(sorted)
+		h.delay.Store(int64(sorted[int(h.Percentile*float64(len(sorted)-1))]))
+	}
+}
+
+// hedgeDelay returns how long to wait for a peer before hedging.
+func (h *hedger) hedgeDelay() time.Duration {
+	d := time.Duration(h.delay.Load())
+	if d == 0 {
+		return h.MaxDelay
+	}
+	return min(max(d, h.MinDelay), h.MaxDelay)
+}
+
+// contextGetter is a PeerGetter whose requests can be cancelled.
+type contextGetter interface {
+	getContext(ctx context.Context, in *pb.Request, out *pb.Response) error
+}
+
+// loadFromPeer gets key from peer, hedging the load if enabled. Once hedged,
+// the first of the peer and local loads to succeed wins, a not-found error
+// from the peer wins too. If both fail the local error is returned. local
+// reports whether the result is that of the local load. Only the result
+// returned is cached, the losing peer request is cancelled.
+func (g *Group) loadFromPeer(peer PeerGetter, key string) (value ByteView, local bool, err error) {
+	if g.hedge == nil {
+		value, stale, err := g.getFromPeerRetrying(context.Background(), peer, key)
+		value, err = g.cachePeer(key, value, stale, err)
+		return value, false, err
+	}
+
+	type result struct {
+		value ByteView
+		stale bool
+		err   error
+		local bool
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// buffered so that the losing load doesn't block
+	results := make(chan result, 2)
+	go func() {
+		v, stale, err := g.getFromPeerRetrying(ctx, peer, key)
+		results <- result{v, stale, err, false}
+	}()
+	timer := time.NewTimer(g.hedge.hedgeDelay())
+	defer timer.Stop()
+	select {
+	case r := <-results:
+		value, err = g.cachePeer(key, r.value, r.stale, r.err)
+		return value, false, err
+	case <-timer.C:
+	}
+
+	g.Stats.Hedges.Add(1)
+	go func() {
+		// the Getter can't be cancelled, a losing value is dropped
+		v, err := g.loadLocally(key)
+		results <- result{v, false, err, true}
+	}()
+	r := <-results
+	if r.err != nil && (r.local || !IsNotFound(r.err)) {
+		if r2 := <-results; r2.err == nil || r2.local {
+			r = r2
+		}
+	}
+	cancel()
+	if r.local {
+		value, err = g.cacheLocal(key, r.value, r.err)
+		if err == nil {
+			g.Stats.HedgeWins.Add(1)
+		}
+		return value, true, err
+	}
+	value, err = g.cachePeer(key, r.value, r.stale, r.err)
+	return value, false, err
+}
+
+// getFromPeerRetrying gets key from peer, retrying retryable errors until
+// ctx is done.
+func (g *Group) getFromPeerRetrying(ctx context.Context, peer PeerGetter, key string) (value ByteView, stale bool, err error) {
+	backoff := g.retry.Backoff
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		value, stale, err = g.getFromPeer(ctx, peer, key)
+		if err == nil {
+			if g.hedge != nil {
+				g.hedge.observe(time.Since(start))
+			}
+			return value, stale, nil
+		}
+		if attempt >= g.retry.MaxRetries || !retryable(err) {
+			return ByteView{}, false, err
+		}
+		g.Stats.PeerRetries.Add(1)
+		// full jitter
+		timer := time.NewTimer(rand.N(backoff))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ByteView{}, false, ctx.Err()
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, g.retry.MaxBackoff)
+	}
+}
+
+// retryable reports whether a failed peer load may succeed if tried again.
+func retryable(err error) bool {
+	var pe *PeerError
+	if errors.As(err, &pe) {
+		return pe.Retryable
+	}
+	return errors.Is(err, ErrPeerUnavailable) || errors.Is(err, ErrTimeout)
+}
@@ -0,0 +1,89 @@
+package mycache
+
+import "time"
+
+// An EvictReason says why a value left the cache of a group.
+type EvictReason int
+
+const (
+	// EvictCapacity values were evicted to make room for others.
+	EvictCapacity EvictReason = iota
+	// EvictExpired values were read past their TTL, and past the
+	// stale-while-revalidate window if any.
+	EvictExpired
+	// EvictRemoved values were removed by Remove.
+	EvictRemoved
+	// EvictInvalidated values were replaced by a newer value of their key,
+	// or dropped with their deleted group.
+	EvictInvalidated
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictExpired:
+		return "expired"
+	case EvictRemoved:
+		return "removed"
+	case EvictInvalidated:
+		return "invalidated"
+	}
+	return "unknown"
+}
+
+// A LoadSource says where a value was loaded from.
+type LoadSource int
+
+const (
+	// LoadLocal values were loaded from the group's Getter.
+	LoadLocal LoadSource = iota
+	// LoadPeer values were loaded from a peer.
+	LoadPeer
+)
+
+func (s LoadSource) String() string {
+	if s == LoadPeer {
+		return "peer"
+	}
+	return "local"
+}
+
+// Hooks are called on the events of a group, see SetHooks. They are called
+// without any lock of the group held, so they may use the group, but they
+// slow down the operation they are called from.
+type Hooks struct {
+	// OnEvict is called with the values leaving the memory caches of the
+	// group and why. Values demoted to the disk tier are not reported, nor
+	// are the values the disk tier evicts.
+	OnEvict func(key string, value ByteView, reason EvictReason)
+	// OnLoad is called after every load of a key from the Getter or from a
+	// peer, with how long it took and its error, if any. Retried and hedged
+	// loads are reported once per attempt.
+	OnLoad func(key string, source LoadSource, latency time.Duration, err error)
+	// OnHit is called when a Get finds the key in the cache, stale or not.
+	OnHit func(key string)
+}
+
+// SetHooks sets the hooks of the group. It must be called before the group
+// is used.
+func (g *Group) SetHooks(h Hooks) {
+	g.hooks = h
+	g.mainCache.onEvict = h.OnEvict
+	g.hotCache.onEvict = h.OnEvict
+}
+
+// WithHooks sets the hooks of the group, see SetHooks.
+func WithHooks(h Hooks) Option {
+	return func(g *Group) error {
+		g.SetHooks(h)
+		return nil
+	}
+}
+
+// loaded reports a load of key started at start to the OnLoad hook.
+func (g *Group) loaded(key string, source LoadSource, start time.Time, err error) {
+	if g.hooks.OnLoad != nil {
+		g.hooks.OnLoad(key, source, time.Since(start), err)
+	}
+}
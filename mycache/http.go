@@ -1,6 +1,9 @@
 package mycache
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"mycache/consistenthash"
 	pb "mycache/mycachepb"
@@ -8,8 +11,11 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 )
@@ -17,6 +23,26 @@ import (
 const defaultBasePath = "/_geecache/"
 const defaultReplicas = 50
 
+// Membership paths under basePath, peers POST the URL of a node as the "peer" form value.
+const (
+	joinPath  = "_join"
+	leavePath = "_leave"
+)
+
+// errorContentType marks response bodies holding a protobuf Error.
+const errorContentType = "application/vnd.mycache.error+protobuf"
+
+// acceptEncodingHeader lists the compressions a peer accepts values in,
+// separated by commas. Values are sent compressed only if the owner stores
+// them compressed with one of them, see Response.encoding.
+const acceptEncodingHeader = "X-Mycache-Accept-Encoding"
+
+// transferPath under basePath receives entries moved by other peers as a protobuf TransferRequest.
+const transferPath = "_transfer"
+
+// maxRequestBody bounds the bodies of peer requests the pool reads.
+const maxRequestBody = 64 << 20
+
 // HTTP pool implements PeerPicker for a pool of HTTP peer
 // HTTPPool implements a pool of HTTP peers that can be used for distributed caching.
 type HTTPPool struct {
@@ -30,19 +56,121 @@ type HTTPPool struct {
 	peers *consistenthash.Map
 	// httpGetter is a map that stores the HTTP client for each peer URL.
 	httpGetter map[string]*httpGetter
+	// peerList is the list of peers last given to Set, including joins and leaves.
+	peerList []string
+	// draining is set once the pool leaves the ring, it then refuses peer requests.
+	draining atomic.Bool
+	// leftRing is the ring from before Leave, Handoff sends the keys it assigned to p.
+	leftRing *consistenthash.Map
+	// rebalancer, if set, is told about every ring change.
+	rebalancer *Rebalancer
+	// client sends the requests to peers.
+	client *http.Client
+	// tlsConfig is the TLS configuration of the server serving the pool.
+	tlsConfig *tls.Config
+	// verifyPeers rejects requests without a client certificate of a peer.
+	verifyPeers bool
+	// auth, if set, signs requests to peers and authenticates theirs.
+	auth Authenticator
+	// acls, if set, lists the callers allowed on each group.
+	acls map[string]GroupACL
+	// openMembership accepts joins, leaves, load leases and transfers from
+	// unauthenticated callers.
+	openMembership bool
+	// admission, if set, decides which get requests are served.
+	admission *admission
+	// registry holds the groups the pool serves.
+	registry *Registry
+}
+
+// HTTPPoolOptions are the configurations of a HTTPPool.
+type HTTPPoolOptions struct {
+	// Client, if set, sends the requests to peers.
+	// For mutual TLS its transport presents this node's client certificate.
+	Client *http.Client
+	// Transport, if set and Client is not, is the RoundTripper of the
+	// client used for peers.
+	Transport http.RoundTripper
+	// TransportOptions set up the pool's own transport when neither Client
+	// nor Transport is set, see NewTransport.
+	TransportOptions TransportOptions
+	// TLSConfig is the TLS configuration Server serves the pool with.
+	// Setting its ClientAuth and ClientCAs enables mutual TLS.
+	TLSConfig *tls.Config
+	// VerifyPeers rejects requests that don't come with a verified client
+	// certificate valid for the host of one of the peers, or for the host
+	// of the node asking to join. It needs mutual TLS.
+	VerifyPeers bool
+	// Auth, if set, signs the requests to peers and rejects peer requests
+	// it can't authenticate with 401 Unauthorized.
+	Auth Authenticator
+	// ACLs lists the callers allowed on each group, requests they don't
+	// allow are rejected with 403 Forbidden. Callers are named by Auth.
+	// If ACLs is nil any caller may do anything, otherwise groups without
+	// an ACL are closed to every caller. Joining or leaving the ring moves
+	// keys of every group, so it needs write permission on all of them.
+	ACLs map[string]GroupACL
+	// OpenMembership accepts requests to join or leave the ring, load
+	// leases and transfers of keys from any caller when peers are not
+	// authenticated, by Auth or VerifyPeers. Anyone who can reach the pool
+	// can then reroute keys to a server of theirs or set their values, so
+	// it is only safe on trusted networks. Without it such a ring only
+	// changes through Set, and has neither load leases nor transfers.
+	OpenMembership bool
+	// Admission rate limits get requests and sheds them when the pool is
+	// overloaded.
+	Admission AdmissionOptions
+	// Registry holds the groups the pool serves, and whose keys it hands
+	// off and rebalances. It defaults to DefaultRegistry.
+	Registry *Registry
 }
 
 type httpGetter struct {
 	baseURL string
+	client  *http.Client
+	auth    Authenticator
+	// v1Until is when, in Unix nanoseconds, the peer is asked with protocol
+	// v2 again after it turned out not to support it, see v1RetryInterval.
+	v1Until atomic.Int64
+	// retryAt is when, in Unix nanoseconds, the peer asked to be sent
+	// requests again after shedding one, see backOff.
+	retryAt atomic.Int64
 }
 
 // NewHTTPoll initializes an HTTP pool of peers
 func NewHTTPPool(self string) *HTTPPool {
+	return NewHTTPPoolOpts(self, nil)
+}
 
-	return &HTTPPool{
+// NewHTTPPoolOpts initializes an HTTP pool of peers with the given options.
+// A nil o is the same as the zero options.
+func NewHTTPPoolOpts(self string, o *HTTPPoolOptions) *HTTPPool {
+	if o == nil {
+		o = &HTTPPoolOptions{}
+	}
+	p := &HTTPPool{
 		self:     self,
 		basePath: defaultBasePath,
 	}
+	switch {
+	case o.Client != nil:
+		p.client = o.Client
+	case o.Transport != nil:
+		p.client = &http.Client{Transport: o.Transport}
+	default:
+		p.client = &http.Client{Transport: NewTransport(o.TransportOptions)}
+	}
+	p.tlsConfig = o.TLSConfig
+	p.verifyPeers = o.VerifyPeers
+	p.auth = o.Auth
+	p.acls = o.ACLs
+	p.openMembership = o.OpenMembership
+	p.admission = newAdmission(o.Admission)
+	p.registry = o.Registry
+	if p.registry == nil {
+		p.registry = DefaultRegistry
+	}
+	return p
 }
 
 // Log info with server name
@@ -56,36 +184,149 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		panic("HTTPPool severing unexpected path " + r.URL.Path)
 	}
 	p.Log("%s %s", r.Method, r.URL.Path)
+	w.Header().Set(protocolHeader, strconv.Itoa(protocolVersion))
+	// authenticate first: fromPeer parses join forms, reading the body
+	// signatures cover
+	var caller string
+	if p.auth != nil {
+		var err error
+		if caller, err = p.auth.Authenticate(r); err != nil {
+			writeError(w, fmt.Errorf("%w: %v", ErrUnauthenticated, err))
+			return
+		}
+	}
+	if p.verifyPeers && !p.fromPeer(r) {
+		writeError(w, fmt.Errorf("%w: peer certificate required", ErrForbidden))
+		return
+	}
+	switch r.URL.Path[len(p.basePath):] {
+	case joinPath:
+		p.serveMembership(w, r, caller, p.addPeer)
+		return
+	case leavePath:
+		p.serveMembership(w, r, caller, p.removePeer)
+		return
+	}
+	if p.draining.Load() {
+		writeError(w, fmt.Errorf("%w: server is shutting down", ErrPeerUnavailable))
+		return
+	}
+	if r.URL.Path[len(p.basePath):] == transferPath {
+		p.serveTransfer(w, r, caller)
+		return
+	}
+	if r.URL.Path[len(p.basePath):] == leasePath {
+		p.serveLease(w, r, caller)
+		return
+	}
+	if r.URL.Path[len(p.basePath):] == v2GetPath {
+		p.serveGetV2(w, r, caller)
+		return
+	}
+
 	// /<basePath>/<groupName>/<key> required
 	parts := strings.SplitN(r.URL.Path[len(p.basePath):], "/", 2)
 	if len(parts) != 2 {
-		http.Error(w, "bad Request", http.StatusBadRequest)
+		writeError(w, fmt.Errorf("%w: path must be <group>/<key>", errBadRequest))
 		return
 	}
 
 	groupName := parts[0]
 	key := parts[1]
 
-	group := GetGroup(groupName)
+	perm := PermRead
+	if r.Method == http.MethodDelete {
+		perm = PermInvalidate
+	}
+	if !p.allowed(caller, groupName, perm) {
+		writeError(w, fmt.Errorf("%w: %q may not access group %s", ErrForbidden, caller, groupName))
+		return
+	}
+
+	group := p.registry.Group(groupName)
 	if group == nil {
-		http.Error(w, "no such group"+groupName, http.StatusNotFound)
+		writeError(w, fmt.Errorf("%w: %s", ErrNoSuchGroup, groupName))
+		return
+	}
+	if r.Method == http.MethodDelete {
+		group.Remove(key)
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	view, err := group.Get(key)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	done, ok := p.admit(w, r, caller, group, key)
+	if !ok {
 		return
 	}
+	defer done()
+	writeValue(w, group, key, acceptedEncodings(r))
+}
 
-	// Write the value to the response body as a protobuf message
-	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice()})
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// fromPeer reports whether r comes with a verified client certificate valid
+// for the host of one of the peers, or of the node asking to join.
+func (p *HTTPPool) fromPeer(r *http.Request) bool {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return false
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	p.mu.Lock()
+	peers := append([]string(nil), p.peerList...)
+	p.mu.Unlock()
+	if r.URL.Path == p.basePath+joinPath {
+		peers = append(peers, r.FormValue("peer"))
+	}
+	for _, peer := range peers {
+		if u, err := url.Parse(peer); err == nil && cert.VerifyHostname(u.Hostname()) == nil {
+			return true
+		}
 	}
+	return false
+}
 
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Write(body)
+// readBody reads the body of a peer request, up to maxRequestBody bytes.
+func readBody(r *http.Request) ([]byte, error) {
+	return io.ReadAll(http.MaxBytesReader(nil, r.Body, maxRequestBody))
+}
+
+// acceptedEncodings returns the compressions the peer making r accepts values in.
+func acceptedEncodings(r *http.Request) []string {
+	var encodings []string
+	for _, v := range strings.Split(r.Header.Get(acceptEncodingHeader), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			encodings = append(encodings, v)
+		}
+	}
+	return encodings
+}
+
+// peerWritesAllowed reports whether the pool takes requests that change
+// what other callers get, such as joins: only from authenticated peers,
+// unless membership is open.
+func (p *HTTPPool) peerWritesAllowed() bool {
+	return p.auth != nil || p.verifyPeers || p.openMembership
+}
+
+// serveMembership handles a peer joining or leaving the ring.
+func (p *HTTPPool) serveMembership(w http.ResponseWriter, r *http.Request, caller string, update func(peer string)) {
+	if !p.peerWritesAllowed() {
+		writeError(w, fmt.Errorf("%w: membership changes need authenticated peers", ErrForbidden))
+		return
+	}
+	if !p.mayChangeRing(caller) {
+		writeError(w, fmt.Errorf("%w: %q may not change the ring", ErrForbidden, caller))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, fmt.Errorf("%w: method not allowed", errBadRequest))
+		return
+	}
+	peer := r.FormValue("peer")
+	if peer == "" {
+		writeError(w, fmt.Errorf("%w: peer is required", errBadRequest))
+		return
+	}
+	update(peer)
+	w.WriteHeader(http.StatusNoContent)
 }
 
 // Set sets the list of peers in the HTTPPool.
@@ -95,14 +336,131 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (p *HTTPPool) Set(peers ...string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.setLocked(peers)
+}
+
+func (p *HTTPPool) setLocked(peers []string) {
+	p.peerList = append([]string(nil), peers...)
 	p.peers = consistenthash.New(defaultReplicas, nil)
 	p.peers.Add(peers...)
 	p.httpGetter = make(map[string]*httpGetter, len(peers))
 	for _, peer := range peers {
 		p.httpGetter[peer] = &httpGetter{
 			baseURL: peer + p.basePath,
+			client:  p.client,
+			auth:    p.auth,
 		}
 	}
+	// once draining, Handoff decides what to move
+	if p.rebalancer != nil && !p.draining.Load() {
+		p.rebalancer.ringChanged()
+	}
+}
+
+// addPeer adds peer to the ring if it is not part of it.
+func (p *HTTPPool) addPeer(peer string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, v := range p.peerList {
+		if v == peer {
+			return
+		}
+	}
+	p.Log("peer %s joined", peer)
+	p.setLocked(append(p.peerList, peer))
+}
+
+// removePeer takes peer off the ring.
+func (p *HTTPPool) removePeer(peer string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := make([]string, 0, len(p.peerList))
+	for _, v := range p.peerList {
+		if v != peer {
+			peers = append(peers, v)
+		}
+	}
+	if len(peers) != len(p.peerList) {
+		p.Log("peer %s left", peer)
+		p.setLocked(peers)
+	}
+}
+
+// otherPeers returns the peers other than p itself.
+func (p *HTTPPool) otherPeers() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := make([]string, 0, len(p.peerList))
+	for _, v := range p.peerList {
+		if v != p.self {
+			peers = append(peers, v)
+		}
+	}
+	return peers
+}
+
+// Join asks every peer to add this node to its ring.
+// It is best effort, peers that can't be reached are logged and skipped.
+func (p *HTTPPool) Join(ctx context.Context) {
+	p.draining.Store(false)
+	p.announceTo(ctx, p.otherPeers(), joinPath)
+}
+
+// Leave stops serving peer requests, takes this node off its own ring so
+// local loads go to the remaining owners, and asks every peer to do the same.
+func (p *HTTPPool) Leave(ctx context.Context) {
+	p.draining.Store(true)
+	peers := p.otherPeers()
+	p.mu.Lock()
+	p.leftRing = p.peers
+	p.mu.Unlock()
+	p.removePeer(p.self)
+	p.announceTo(ctx, peers, leavePath)
+}
+
+// announceTo posts this node's URL to path on each of peers concurrently.
+func (p *HTTPPool) announceTo(ctx context.Context, peers []string, path string) {
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			form := url.Values{"peer": {p.self}}
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer+p.basePath+path, strings.NewReader(form.Encode()))
+			if err != nil {
+				p.Log("%s %s: %v", path, peer, err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			res, err := send(p.client, p.auth, req)
+			if err != nil {
+				p.Log("%s %s: %v", path, peer, err)
+				return
+			}
+			res.Body.Close()
+			if res.StatusCode != http.StatusNoContent {
+				p.Log("%s %s: %v", path, peer, responseError(res))
+			}
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// Handoff sends up to n of the most recently used keys this node owned
+// before Leave to the peers that own them now, so they don't start cold.
+func (p *HTTPPool) Handoff(ctx context.Context, n int) error {
+	p.mu.Lock()
+	base, r := p.leftRing, p.rebalancer
+	p.mu.Unlock()
+	current, getters := p.ring()
+
+	batch, rate := defaultTransferBatch, 0
+	if r != nil {
+		batch, rate = r.BatchSize, r.Rate
+	}
+	sent, err := p.sendMoved(ctx, base, current, getters, n, batch, rate)
+	p.Log("handed off %d keys", sent)
+	return err
 }
 
 // PickPeer picks a peer according to key.
@@ -110,6 +468,9 @@ func (p *HTTPPool) Set(peers ...string) {
 func (p *HTTPPool) PickPeer(key string) (peer PeerGetter, ok bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil, false
+	}
 	// peer not refer to p itself
 	if peer := p.peers.Get(key); peer != "" && peer != p.self {
 		p.Log("pick peer %s", peer)
@@ -119,26 +480,53 @@ func (p *HTTPPool) PickPeer(key string) (peer PeerGetter, ok bool) {
 }
 
 // Get retrieves the value associated with the given group and key from the remote cache server.
-// It returns the value as a byte slice and an error if any occurred.
+// It uses protocol v2, or v1 for peers that don't support it.
 func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
-	group := in.GetGroup()
-	key := in.GetKey()
-	u := fmt.Sprintf("%v%v/%v",
-		h.baseURL,
-		url.QueryEscape(group),
-		url.QueryEscape(key),
-	)
+	return h.getContext(context.Background(), in, out)
+}
 
-	res, err := http.Get(u)
+// getContext is Get with a context that cancels the request.
+func (h *httpGetter) getContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	if err := h.backingOff(); err != nil {
+		return err
+	}
+	if !h.useV1() {
+		err := h.getV2(ctx, in, out)
+		if err != errNoV2 {
+			return err
+		}
+		h.v1Until.Store(time.Now().Add(v1RetryInterval).UnixNano())
+	}
+	return h.getV1(ctx, in, out)
+}
+
+// useV1 reports whether the peer is asked with protocol v1.
+func (h *httpGetter) useV1() bool {
+	return time.Now().UnixNano() < h.v1Until.Load()
+}
+
+// getV1 gets a value with protocol v1.
+func (h *httpGetter) getV1(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url(in.GetGroup(), in.GetKey()), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(acceptEncodingHeader, strings.Join(compressorNames(), ","))
+	res, err := send(h.client, h.auth, req)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server return: %v", res.Status)
+		h.backOff(res)
+		return responseError(res)
 	}
+	return readResponse(res, out)
+}
 
+// readResponse decodes the protobuf body of a successful response into out.
+func readResponse(res *http.Response, out proto.Message) error {
 	bytes, err := io.ReadAll(res.Body)
 	if err != nil {
 		return fmt.Errorf("reading response body: %v", err)
@@ -148,3 +536,91 @@ func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
 	}
 	return nil
 }
+
+// transfer sends a batch of entries to the peer.
+func (h *httpGetter) transfer(ctx context.Context, in *pb.TransferRequest, out *pb.TransferResponse) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.baseURL+transferPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	res, err := send(h.client, h.auth, req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return responseError(res)
+	}
+	return readResponse(res, out)
+}
+
+// send signs req with auth, if set, and sends it with client.
+func send(client *http.Client, auth Authenticator, req *http.Request) (*http.Response, error) {
+	if auth != nil {
+		if err := auth.Sign(req); err != nil {
+			return nil, err
+		}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, transportError(err)
+	}
+	return res, nil
+}
+
+// writeError replies to a peer with err as a protobuf Error, with the HTTP
+// status of its code.
+func writeError(w http.ResponseWriter, err error) {
+	e := toPB(err)
+	body, merr := proto.Marshal(e)
+	if merr != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", errorContentType)
+	w.WriteHeader(codeStatus[e.GetCode()])
+	w.Write(body)
+}
+
+// responseError returns the error of an unsuccessful response from a peer.
+// It is a *PeerError, also when the response did not come from a peer but
+// from a proxy in between.
+func responseError(res *http.Response) error {
+	if res.Header.Get("Content-Type") == errorContentType {
+		body, err := io.ReadAll(res.Body)
+		e := &pb.Error{}
+		if err == nil && proto.Unmarshal(body, e) == nil {
+			return fromPB(e)
+		}
+	}
+	code := pb.Code_UNKNOWN
+	switch res.StatusCode {
+	case http.StatusUnauthorized:
+		code = pb.Code_UNAUTHENTICATED
+	case http.StatusForbidden:
+		code = pb.Code_FORBIDDEN
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		code = pb.Code_UNAVAILABLE
+	case http.StatusGatewayTimeout:
+		code = pb.Code_TIMEOUT
+	}
+	return &PeerError{
+		Code:      code,
+		Message:   fmt.Sprintf("server return: %v", res.Status),
+		Retryable: code == pb.Code_UNAVAILABLE || code == pb.Code_TIMEOUT,
+	}
+}
+
+func (h *httpGetter) url(group, key string) string {
+	return fmt.Sprintf("%v%v/%v",
+		h.baseURL,
+		url.PathEscape(group),
+		url.PathEscape(key),
+	)
+}
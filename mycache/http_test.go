@@ -0,0 +1,1022 @@
+package mycache
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"math/big"
+	pb "mycache/mycachepb"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestRebalance(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]bool)
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != defaultBasePath+transferPath {
+			http.Error(w, "unexpected path", http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		req := &pb.TransferRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		// groups of other tests are rebalanced too
+		if req.GetGroup() == "rebalanceGroup" {
+			mu.Lock()
+			for _, e := range req.GetEntries() {
+				received[string(e.GetKey())] = true
+			}
+			mu.Unlock()
+		}
+		res, _ := proto.Marshal(&pb.TransferResponse{Accepted: int64(len(req.GetEntries()))})
+		w.Write(res)
+	}))
+	defer peer.Close()
+
+	self := "http://self.invalid"
+	pool := NewHTTPPool(self)
+	pool.Set(self)
+	r := NewRebalancer(pool)
+	r.BatchSize = 7
+	r.Delay = time.Hour // rebalance by hand
+
+	g := newTestGroup(t, "rebalanceGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	g.RegisterPeers(pool)
+	for i := 0; i < 50; i++ {
+		g.Get(strconv.Itoa(i))
+	}
+	// keys need not be valid UTF-8
+	g.Get("\xff\x00binary")
+
+	pool.Set(self, peer.URL)
+	if err := r.Rebalance(context.Background()); err != nil {
+		t.Fatalf("rebalance failed: %v", err)
+	}
+
+	current, _ := pool.ring()
+	keys := []string{"\xff\x00binary"}
+	for i := 0; i < 50; i++ {
+		keys = append(keys, strconv.Itoa(i))
+	}
+	for _, key := range keys {
+		if moved := current.Get(key) == peer.URL; moved != received[key] {
+			t.Errorf("key %s: moved to peer %v, received %v", key, moved, received[key])
+		}
+	}
+	if len(received) == 0 {
+		t.Fatalf("no keys were moved")
+	}
+
+	// a second rebalance on the same ring has nothing to move
+	received = make(map[string]bool)
+	if err := r.Rebalance(context.Background()); err != nil || len(received) != 0 {
+		t.Fatalf("second rebalance should move nothing, moved %d, err %v", len(received), err)
+	}
+
+	// without auth, only a pool with open membership takes transfers
+	transfer := func(pool *HTTPPool) int {
+		body, _ := proto.Marshal(&pb.TransferRequest{Group: "rebalanceGroup", Entries: []*pb.Entry{{Key: []byte("0"), Value: []byte("forged")}}})
+		w := httptest.NewRecorder()
+		pool.ServeHTTP(w, httptest.NewRequest(http.MethodPost, defaultBasePath+transferPath, bytes.NewReader(body)))
+		return w.Code
+	}
+	if code := transfer(pool); code != http.StatusForbidden {
+		t.Fatalf("unauthenticated transfer should be forbidden, got %d", code)
+	}
+	if v, err := g.Get("0"); err != nil || v.String() != "0" {
+		t.Fatalf("forbidden transfer should not change the cache, got %q, %v", v.String(), err)
+	}
+	open := NewHTTPPoolOpts(self, &HTTPPoolOptions{OpenMembership: true})
+	if code := transfer(open); code != http.StatusOK {
+		t.Fatalf("open membership should accept transfers, got %d", code)
+	}
+}
+
+// freeAddr returns a local address nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func contains(peers []string, peer string) bool {
+	for _, p := range peers {
+		if p == peer {
+			return true
+		}
+	}
+	return false
+}
+
+func TestServerShutdown(t *testing.T) {
+	// the staying node
+	var stay *HTTPPool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stay.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	stayReg := NewRegistry()
+	stay = NewHTTPPoolOpts(srv.URL, &HTTPPoolOptions{Registry: stayReg, OpenMembership: true})
+	stay.Set(srv.URL)
+	kept := newTestGroup(t, "handoffGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}), WithRegistry(stayReg))
+
+	// the leaving node joins when it starts
+	addr := freeAddr(t)
+	self := "http://" + addr
+	leaveReg := NewRegistry()
+	leave := NewHTTPPoolOpts(self, &HTTPPoolOptions{Registry: leaveReg, OpenMembership: true})
+	leave.Set(self, srv.URL)
+	g := newTestGroup(t, "handoffGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value of " + key), nil
+	}), WithRegistry(leaveReg))
+	server := NewServer(leave, addr)
+	server.HandoffKeys = 100
+	served := make(chan error, 1)
+	go func() { served <- server.ListenAndServe() }()
+	for deadline := time.Now().Add(time.Second); !contains(stay.otherPeers(), self); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("starting server should join the ring of its peers")
+		}
+	}
+
+	old, _ := leave.ring()
+	var keys []string
+	for i := 0; i < 20; i++ {
+		key := strconv.Itoa(i)
+		keys = append(keys, key)
+		g.Get(key) // cached here whoever owns it, g has no peers
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("ListenAndServe should return ErrServerClosed after Shutdown, got %v", err)
+	}
+	if contains(stay.otherPeers(), self) {
+		t.Fatalf("leaving server should be taken off the ring of its peers")
+	}
+	if current, _ := leave.ring(); current.Get("key") != srv.URL {
+		t.Fatalf("leaving server should take itself off its own ring")
+	}
+	handedOff := 0
+	for _, key := range keys {
+		owned := old.Get(key) == self
+		if owned != kept.cached(key) {
+			t.Errorf("key %s: owned by the leaving server %v, handed off %v", key, owned, !owned)
+		}
+		if owned {
+			handedOff++
+		}
+	}
+	if handedOff == 0 {
+		t.Fatalf("no key was owned by the leaving server")
+	}
+}
+
+func TestMembership(t *testing.T) {
+	joinAs := func(auth Authenticator, pool *HTTPPool, path, peer string) int {
+		r := httptest.NewRequest(http.MethodPost, defaultBasePath+path, strings.NewReader("peer="+peer))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if auth != nil {
+			auth.Sign(r)
+		}
+		w := httptest.NewRecorder()
+		pool.ServeHTTP(w, r)
+		return w.Code
+	}
+	join := func(pool *HTTPPool, path, peer string) int {
+		return joinAs(nil, pool, path, peer)
+	}
+
+	closed := NewHTTPPool("http://self.invalid")
+	closed.Set("http://self.invalid", "http://peer.invalid")
+	if code := join(closed, joinPath, "http://attacker.invalid"); code != http.StatusForbidden {
+		t.Fatalf("unauthenticated join should be forbidden, got %d", code)
+	}
+	if code := join(closed, leavePath, "http://peer.invalid"); code != http.StatusForbidden {
+		t.Fatalf("unauthenticated leave should be forbidden, got %d", code)
+	}
+	if peers := closed.otherPeers(); len(peers) != 1 || peers[0] != "http://peer.invalid" {
+		t.Fatalf("rejected requests should not change the ring, got %v", peers)
+	}
+
+	open := NewHTTPPoolOpts("http://self.invalid", &HTTPPoolOptions{OpenMembership: true})
+	open.Set("http://self.invalid")
+	if code := join(open, joinPath, "http://peer.invalid"); code != http.StatusNoContent || !contains(open.otherPeers(), "http://peer.invalid") {
+		t.Fatalf("open membership should accept joins, got %d", code)
+	}
+	if code := join(open, leavePath, "http://peer.invalid"); code != http.StatusNoContent || len(open.otherPeers()) != 0 {
+		t.Fatalf("open membership should accept leaves, got %d", code)
+	}
+
+	// with ACLs, changing the ring needs write permission on every group
+	r := NewRegistry()
+	newTestGroup(t, "membershipGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value"), nil
+	}), WithRegistry(r))
+	secret := []byte("shared secret")
+	guarded := NewHTTPPoolOpts("http://self.invalid", &HTTPPoolOptions{
+		Auth:     &HMACAuth{Caller: "self", Secret: secret},
+		Registry: r,
+		ACLs: map[string]GroupACL{
+			"membershipGroup": {Read: []string{AnyCaller}, Write: []string{"peer"}},
+			"otherGroup":      {Read: []string{AnyCaller}, Write: []string{"peer", "writer"}},
+		},
+	})
+	guarded.Set("http://self.invalid", "http://peer.invalid")
+	for _, caller := range []string{"reader", "writer"} {
+		auth := &HMACAuth{Caller: caller, Secret: secret}
+		if code := joinAs(auth, guarded, joinPath, "http://attacker.invalid"); code != http.StatusForbidden {
+			t.Errorf("%s: join without write on every group should be forbidden, got %d", caller, code)
+		}
+		if code := joinAs(auth, guarded, leavePath, "http://peer.invalid"); code != http.StatusForbidden {
+			t.Errorf("%s: leave without write on every group should be forbidden, got %d", caller, code)
+		}
+	}
+	if peers := guarded.otherPeers(); len(peers) != 1 || peers[0] != "http://peer.invalid" {
+		t.Fatalf("forbidden requests should not change the ring, got %v", peers)
+	}
+	peer := &HMACAuth{Caller: "peer", Secret: secret}
+	if code := joinAs(peer, guarded, joinPath, "http://new.invalid"); code != http.StatusNoContent || !contains(guarded.otherPeers(), "http://new.invalid") {
+		t.Fatalf("peer writing every group should join, got %d", code)
+	}
+}
+
+func TestPeerNotFound(t *testing.T) {
+	newTestGroup(t, "peerNotFoundGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}))
+	pool := NewHTTPPool("http://owner.invalid")
+	srv := httptest.NewServer(pool)
+	defer srv.Close()
+
+	peer := &httpGetter{baseURL: srv.URL + defaultBasePath, client: http.DefaultClient}
+	err := peer.Get(&pb.Request{Group: "peerNotFoundGroup", Key: "unknown"}, &pb.Response{})
+	if !IsNotFound(err) || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("owner's not-found should be reported as not found, got %v", err)
+	}
+	if err.Error() != "mycache: not found: unknown" {
+		t.Fatalf("owner's error message should be kept, got %q", err.Error())
+	}
+
+	err = peer.Get(&pb.Request{Group: "noSuchGroup", Key: "unknown"}, &pb.Response{})
+	if err == nil || IsNotFound(err) || !errors.Is(err, ErrNoSuchGroup) {
+		t.Fatalf("unknown group should not be reported as a missing key, got %v", err)
+	}
+}
+
+func TestRegistryIsolation(t *testing.T) {
+	var peers []*httpGetter
+	for _, cluster := range []string{"a", "b"} {
+		r := NewRegistry()
+		newTestGroup(t, "sharedGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+			return []byte(cluster), nil
+		}), WithRegistry(r))
+		srv := httptest.NewServer(NewHTTPPoolOpts("http://"+cluster+".invalid", &HTTPPoolOptions{Registry: r}))
+		defer srv.Close()
+		peers = append(peers, &httpGetter{baseURL: srv.URL + defaultBasePath, client: http.DefaultClient})
+	}
+	if GetGroup("sharedGroup") != nil {
+		t.Fatalf("groups of a registry should not be in the default registry")
+	}
+
+	for i, want := range []string{"a", "b"} {
+		res := &pb.Response{}
+		if err := peers[i].Get(&pb.Request{Group: "sharedGroup", Key: "key"}, res); err != nil || string(res.Value) != want {
+			t.Fatalf("pool %s should serve the group of its registry, got %q, %v", want, res.Value, err)
+		}
+	}
+}
+
+func TestPeerErrors(t *testing.T) {
+	release := make(chan struct{})
+	newTestGroup(t, "peerErrorsGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		switch key {
+		case "slow":
+			<-release
+			return []byte("late"), nil
+		case "timeout":
+			return nil, fmt.Errorf("origin: %w", context.DeadlineExceeded)
+		}
+		return nil, errors.New("origin exploded")
+	}))
+	pool := NewHTTPPool("http://owner.invalid")
+	srv := httptest.NewServer(pool)
+	defer srv.Close()
+	defer close(release)
+	peer := &httpGetter{baseURL: srv.URL + defaultBasePath, client: http.DefaultClient}
+	get := func(peer *httpGetter, key string) *PeerError {
+		t.Helper()
+		err := peer.Get(&pb.Request{Group: "peerErrorsGroup", Key: key}, &pb.Response{})
+		var pe *PeerError
+		if !errors.As(err, &pe) {
+			t.Fatalf("Get(%s) = %v, want a PeerError", key, err)
+		}
+		return pe
+	}
+
+	if e := get(peer, "key"); e.Code != pb.Code_INTERNAL || e.Retryable || e.Message != "origin exploded" {
+		t.Errorf("failed load should be an internal error, got %+v", e)
+	}
+	if e := get(peer, "timeout"); !errors.Is(e, ErrTimeout) || !e.Retryable {
+		t.Errorf("timed out load should be a retryable timeout, got %+v", e)
+	}
+
+	slow := &httpGetter{baseURL: peer.baseURL, client: &http.Client{Timeout: 50 * time.Millisecond}}
+	err := slow.Get(&pb.Request{Group: "peerErrorsGroup", Key: "slow"}, &pb.Response{})
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("request timing out should match ErrTimeout, got %v", err)
+	}
+
+	pool.draining.Store(true)
+	if e := get(peer, "key"); !errors.Is(e, ErrPeerUnavailable) || !e.Retryable {
+		t.Errorf("draining peer should be unavailable, got %+v", e)
+	}
+	pool.draining.Store(false)
+
+	gone := &httpGetter{baseURL: "http://127.0.0.1:1" + defaultBasePath, client: http.DefaultClient}
+	err = gone.Get(&pb.Request{Group: "peerErrorsGroup", Key: "key"}, &pb.Response{})
+	if !errors.Is(err, ErrPeerUnavailable) {
+		t.Errorf("unreachable peer should match ErrPeerUnavailable, got %v", err)
+	}
+}
+
+func TestPeerCompression(t *testing.T) {
+	value := strings.Repeat("compressible ", 100)
+	owner := newTestGroup(t, "peerCompressionGroup", 8<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(value), nil
+	}))
+	owner.SetCompression(Gzip, 64)
+	srv := httptest.NewServer(NewHTTPPool("http://owner.invalid"))
+	defer srv.Close()
+	peer := &httpGetter{baseURL: srv.URL + defaultBasePath, client: http.DefaultClient}
+
+	res := &pb.Response{}
+	if err := peer.Get(&pb.Request{Group: "peerCompressionGroup", Key: "key"}, res); err != nil {
+		t.Fatal(err)
+	}
+	if res.GetEncoding() != "gzip" || len(res.GetValue()) >= len(value) {
+		t.Fatalf("value should be sent compressed, got %q encoding and %d bytes", res.GetEncoding(), len(res.GetValue()))
+	}
+	if res.GetVersion() != crc32.Checksum([]byte(value), castagnoli) {
+		t.Errorf("version should be of the uncompressed value, got %d", res.GetVersion())
+	}
+
+	// a peer that accepts no compression gets the value as is
+	req, _ := http.NewRequest(http.MethodGet, peer.url("peerCompressionGroup", "key"), nil)
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	body, _ := io.ReadAll(r.Body)
+	res = &pb.Response{}
+	if err := proto.Unmarshal(body, res); err != nil {
+		t.Fatal(err)
+	}
+	if res.GetEncoding() != "" || string(res.GetValue()) != value {
+		t.Fatalf("value should be sent uncompressed, got %q encoding", res.GetEncoding())
+	}
+}
+
+// newTestCA returns a CA certificate and a function issuing client certificates for host signed by it.
+func newTestCA(t *testing.T) (*x509.CertPool, func(host string) tls.Certificate) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mycache test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	serial := int64(1)
+	issue := func(host string) tls.Certificate {
+		t.Helper()
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serial++
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: host},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = []net.IP{ip}
+		} else {
+			template.DNSNames = []string{host}
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+	return pool, issue
+}
+
+func TestTLS(t *testing.T) {
+	newTestGroup(t, "tlsGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value of " + key), nil
+	}))
+	srv := httptest.NewTLSServer(NewHTTPPool("https://owner.invalid"))
+	defer srv.Close()
+
+	pool := NewHTTPPoolOpts("https://self.invalid", &HTTPPoolOptions{Client: srv.Client()})
+	pool.Set(srv.URL)
+	peer, ok := pool.PickPeer("key")
+	if !ok {
+		t.Fatal("the server should own every key")
+	}
+	res := &pb.Response{}
+	if err := peer.Get(&pb.Request{Group: "tlsGroup", Key: "key"}, res); err != nil || string(res.GetValue()) != "value of key" {
+		t.Fatalf("get over TLS = %q, %v", res.GetValue(), err)
+	}
+
+	untrusted := &httpGetter{baseURL: srv.URL + defaultBasePath, client: http.DefaultClient}
+	if err := untrusted.Get(&pb.Request{Group: "tlsGroup", Key: "key"}, &pb.Response{}); err == nil {
+		t.Fatal("a client that doesn't trust the server certificate should fail")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	newTestGroup(t, "mtlsGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value of " + key), nil
+	}))
+	clientCAs, issue := newTestCA(t)
+	owner := NewHTTPPoolOpts("https://owner.invalid", &HTTPPoolOptions{VerifyPeers: true})
+	owner.Set("https://owner.invalid", "https://127.0.0.1:8001")
+	srv := httptest.NewUnstartedServer(owner)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	defer srv.Close()
+
+	clientWith := func(certs ...tls.Certificate) *httpGetter {
+		transport := srv.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = certs
+		return &httpGetter{baseURL: srv.URL + defaultBasePath, client: &http.Client{Transport: transport}}
+	}
+	req := &pb.Request{Group: "mtlsGroup", Key: "key"}
+
+	res := &pb.Response{}
+	if err := clientWith(issue("127.0.0.1")).Get(req, res); err != nil || string(res.GetValue()) != "value of key" {
+		t.Fatalf("get from a peer = %q, %v", res.GetValue(), err)
+	}
+	if err := clientWith().Get(req, &pb.Response{}); err == nil {
+		t.Fatal("a client without a certificate should be rejected")
+	}
+	err := clientWith(issue("stranger.invalid")).Get(req, &pb.Response{})
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("a certificate of a host that isn't a peer should be forbidden, got %v", err)
+	}
+
+	// a new node joins with its certificate and a signed form
+	secret := []byte("shared secret")
+	owner.auth = &HMACAuth{Caller: "owner", Secret: secret}
+	form := url.Values{"peer": {"https://127.0.0.2:8002"}}
+	join, _ := http.NewRequest(http.MethodPost, srv.URL+defaultBasePath+joinPath, strings.NewReader(form.Encode()))
+	join.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	joined, err := send(clientWith(issue("127.0.0.2")).client, &HMACAuth{Caller: "new", Secret: secret}, join)
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined.Body.Close()
+	if joined.StatusCode != http.StatusNoContent || !contains(owner.otherPeers(), "https://127.0.0.2:8002") {
+		t.Fatalf("signed join from a verified peer should be accepted, got %d", joined.StatusCode)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	var loads atomic.Int64
+	g := newTestGroup(t, "authGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte("value of " + key), nil
+	}))
+	newTestGroup(t, "closedGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("secret"), nil
+	}))
+	secret := []byte("shared secret")
+	srv := httptest.NewServer(NewHTTPPoolOpts("http://owner.invalid", &HTTPPoolOptions{
+		Auth: &HMACAuth{Caller: "owner", Secret: secret},
+		ACLs: map[string]GroupACL{
+			"authGroup": {Read: []string{"reader", "admin"}, Invalidate: []string{"admin"}},
+		},
+	}))
+	defer srv.Close()
+	getter := func(auth Authenticator) *httpGetter {
+		return &httpGetter{baseURL: srv.URL + defaultBasePath, client: http.DefaultClient, auth: auth}
+	}
+	get := func(auth Authenticator, group string) error {
+		return getter(auth).Get(&pb.Request{Group: group, Key: "key"}, &pb.Response{})
+	}
+
+	if err := get(&HMACAuth{Caller: "reader", Secret: secret}, "authGroup"); err != nil {
+		t.Fatalf("allowed caller should read, got %v", err)
+	}
+	for name, auth := range map[string]Authenticator{
+		"no credentials": nil,
+		"wrong secret":   &HMACAuth{Caller: "reader", Secret: []byte("guess")},
+		"bearer token":   &BearerAuth{Token: "shared secret"},
+	} {
+		if err := get(auth, "authGroup"); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("%s: should be unauthenticated, got %v", name, err)
+		}
+	}
+	oversized := httptest.NewRequest(http.MethodPost, defaultBasePath+v2GetPath, io.LimitReader(rand.Reader, maxRequestBody+1))
+	oversized.Header.Set(callerHeader, "reader")
+	oversized.Header.Set(timestampHeader, strconv.FormatInt(time.Now().Unix(), 10))
+	oversized.Header.Set(signatureHeader, "00")
+	if _, err := (&HMACAuth{Secret: secret}).Authenticate(oversized); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("request over maxRequestBody should not be read, got %v", err)
+	}
+	if err := get(&HMACAuth{Caller: "stranger", Secret: secret}, "authGroup"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("caller missing from the ACL should be forbidden, got %v", err)
+	}
+	if err := get(&HMACAuth{Caller: "admin", Secret: secret}, "closedGroup"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("group without an ACL should be closed, got %v", err)
+	}
+	transfer := &pb.TransferRequest{Group: "authGroup", Entries: []*pb.Entry{{Key: []byte("key"), Value: []byte("forged")}}}
+	err := getter(&HMACAuth{Caller: "reader", Secret: secret}).transfer(context.Background(), transfer, &pb.TransferResponse{})
+	if !errors.Is(err, ErrForbidden) {
+		t.Errorf("caller without write permission should not transfer, got %v", err)
+	}
+
+	remove := func(caller string) int {
+		req, _ := http.NewRequest(http.MethodDelete, getter(nil).url("authGroup", "key"), nil)
+		res, err := send(http.DefaultClient, &HMACAuth{Caller: caller, Secret: secret}, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if code := remove("reader"); code != http.StatusForbidden {
+		t.Errorf("reader should not invalidate, got %d", code)
+	}
+	if code := remove("admin"); code != http.StatusNoContent {
+		t.Errorf("admin should invalidate, got %d", code)
+	}
+	if _, err := g.Get("key"); err != nil || loads.Load() != 2 {
+		t.Errorf("invalidated key should be loaded again, got %d loads, %v", loads.Load(), err)
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	auth := &BearerAuth{Token: "token-a", Callers: map[string]string{"token-a": "node-a"}}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, err := auth.Authenticate(req); err == nil {
+		t.Fatal("request without a token should not authenticate")
+	}
+	auth.Sign(req)
+	if caller, err := auth.Authenticate(req); err != nil || caller != "node-a" {
+		t.Fatalf("Authenticate = %q, %v", caller, err)
+	}
+	req.Header.Set("Authorization", "Bearer token-b")
+	if _, err := auth.Authenticate(req); err == nil {
+		t.Fatal("unknown token should not authenticate")
+	}
+}
+
+func TestProtocolV2(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	g := newTestGroup(t, "protocolGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		mu.Lock()
+		keys = append(keys, key)
+		mu.Unlock()
+		return []byte("value"), nil
+	}))
+	g.SetTTL(time.Minute)
+	srv := httptest.NewServer(NewHTTPPool("http://owner.invalid"))
+	defer srv.Close()
+	peer := &httpGetter{baseURL: srv.URL + defaultBasePath, client: http.DefaultClient}
+
+	for _, key := range []string{"a b+c/d%2F", "\xff\x00binary"} {
+		res := &pb.Response{}
+		if err := peer.Get(&pb.Request{Group: "protocolGroup", Key: key}, res); err != nil {
+			t.Fatalf("Get(%q): %v", key, err)
+		}
+		if res.GetVersion() != crc32.Checksum([]byte("value"), castagnoli) || res.GetTtl() <= 0 || res.GetTtl() > int64(time.Minute) {
+			t.Errorf("Get(%q): response should carry the version and ttl, got %d and %d", key, res.GetVersion(), res.GetTtl())
+		}
+		// v1 keeps working for keys that are valid in a path
+		if key[0] != '\xff' {
+			if err := peer.getV1(context.Background(), &pb.Request{Group: "protocolGroup", Key: key}, &pb.Response{}); err != nil {
+				t.Fatalf("getV1(%q): %v", key, err)
+			}
+		}
+	}
+	if peer.useV1() {
+		t.Errorf("v2 peer should not be downgraded to v1")
+	}
+	oversized := httptest.NewRequest(http.MethodPost, defaultBasePath+v2GetPath, io.LimitReader(rand.Reader, maxRequestBody+1))
+	w := httptest.NewRecorder()
+	NewHTTPPool("http://owner.invalid").ServeHTTP(w, oversized)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("request over maxRequestBody should be refused, got %d", w.Code)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(keys) != 2 || keys[0] != "a b+c/d%2F" || keys[1] != "\xff\x00binary" {
+		t.Errorf("keys should reach the getter unchanged, got %q", keys)
+	}
+}
+
+func TestProtocolV1Fallback(t *testing.T) {
+	// a peer from before protocol v2
+	var requests []string
+	old := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.URL.Path != defaultBasePath+"oldGroup/key" {
+			http.Error(w, "no such group", http.StatusNotFound)
+			return
+		}
+		body, _ := proto.Marshal(&pb.Response{Value: []byte("old value")})
+		w.Write(body)
+	}))
+	defer old.Close()
+	peer := &httpGetter{baseURL: old.URL + defaultBasePath, client: http.DefaultClient}
+
+	for i := 0; i < 2; i++ {
+		res := &pb.Response{}
+		if err := peer.Get(&pb.Request{Group: "oldGroup", Key: "key"}, res); err != nil || string(res.GetValue()) != "old value" {
+			t.Fatalf("Get from a v1 peer = %q, %v", res.GetValue(), err)
+		}
+	}
+	want := []string{"POST " + defaultBasePath + v2GetPath, "GET " + defaultBasePath + "oldGroup/key", "GET " + defaultBasePath + "oldGroup/key"}
+	if fmt.Sprint(requests) != fmt.Sprint(want) {
+		t.Errorf("v1 peer should be asked with v2 once, got %q", requests)
+	}
+
+	// once v1RetryInterval passed, the peer is asked with v2 again
+	peer.v1Until.Store(time.Now().UnixNano())
+	requests = nil
+	if err := peer.Get(&pb.Request{Group: "oldGroup", Key: "key"}, &pb.Response{}); err != nil || len(requests) != 2 || requests[0] != want[0] {
+		t.Errorf("v1 peer should be asked with v2 again, got %q, %v", requests, err)
+	}
+}
+
+// newH2CServer starts a server for handler accepting peers like Server does.
+// If conns is not nil it counts the connections accepted.
+func newH2CServer(handler http.Handler, conns *atomic.Int64) *httptest.Server {
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Config.Protocols = serverProtocols()
+	if conns != nil {
+		srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				conns.Add(1)
+			}
+		}
+	}
+	srv.Start()
+	return srv
+}
+
+func TestH2C(t *testing.T) {
+	newTestGroup(t, "h2cGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value of " + key), nil
+	}))
+	pool := NewHTTPPool("http://owner.invalid")
+	var protos sync.Map
+	srv := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protos.Store(r.Proto, true)
+		pool.ServeHTTP(w, r)
+	}), nil)
+	defer srv.Close()
+
+	for _, h2c := range []bool{false, true} {
+		client := &http.Client{Transport: NewTransport(TransportOptions{H2C: h2c})}
+		peer := &httpGetter{baseURL: srv.URL + defaultBasePath, client: client}
+		res := &pb.Response{}
+		if err := peer.Get(&pb.Request{Group: "h2cGroup", Key: "key"}, res); err != nil || string(res.GetValue()) != "value of key" {
+			t.Fatalf("h2c %v: Get = %q, %v", h2c, res.GetValue(), err)
+		}
+	}
+	for _, proto := range []string{"HTTP/1.1", "HTTP/2.0"} {
+		if _, ok := protos.Load(proto); !ok {
+			t.Errorf("server should have been reached over %s", proto)
+		}
+	}
+}
+
+// BenchmarkPeerGet compares clients getting a value from a peer over
+// loopback with many requests in flight, reporting the connections opened.
+func BenchmarkPeerGet(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	value := []byte(strings.Repeat("x", 1<<10))
+	newTestGroup(b, "benchmarkGroup", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return value, nil
+	}))
+	var conns atomic.Int64
+	srv := newH2CServer(NewHTTPPool("http://owner.invalid"), &conns)
+	defer srv.Close()
+
+	clients := []struct {
+		name   string
+		client *http.Client
+	}{
+		{"DefaultClient", http.DefaultClient},
+		{"Transport", &http.Client{Transport: NewTransport(TransportOptions{})}},
+		{"H2C", &http.Client{Transport: NewTransport(TransportOptions{H2C: true})}},
+	}
+	for _, c := range clients {
+		b.Run(c.name, func(b *testing.B) {
+			peer := &httpGetter{baseURL: srv.URL + defaultBasePath, client: c.client}
+			conns.Store(0)
+			b.SetParallelism(16)
+			b.ReportAllocs()
+			b.RunParallel(func(p *testing.PB) {
+				for p.Next() {
+					if err := peer.Get(&pb.Request{Group: "benchmarkGroup", Key: "key"}, &pb.Response{}); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(conns.Load()), "conns")
+		})
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	release := make(chan struct{})
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		if strings.HasPrefix(key, "slow") {
+			<-release
+		}
+		return []byte(key), nil
+	})
+	get := func(peer *httpGetter, group, key string) error {
+		return peer.Get(&pb.Request{Group: group, Key: key}, &pb.Response{})
+	}
+	// closed before the servers, which wait for the requests of slow keys
+	defer close(release)
+
+	// rate limits
+	limited := newTestGroup(t, "rateLimitedGroup", 2<<10, getter)
+	srv := httptest.NewServer(NewHTTPPoolOpts("http://owner.invalid", &HTTPPoolOptions{
+		Admission: AdmissionOptions{GroupRate: 0.5, GroupBurst: 2},
+	}))
+	t.Cleanup(srv.Close)
+	peer := &httpGetter{baseURL: srv.URL + defaultBasePath, client: http.DefaultClient}
+	for i := 0; i < 2; i++ {
+		if err := get(peer, "rateLimitedGroup", "key"); err != nil {
+			t.Fatalf("requests within the burst should be served, got %v", err)
+		}
+	}
+	res, err := http.Get(srv.URL + defaultBasePath + "rateLimitedGroup/key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable || res.Header.Get("Retry-After") != "2" {
+		t.Fatalf("request over the rate limit should get 503 with Retry-After 2, got %s %q", res.Status, res.Header.Get("Retry-After"))
+	}
+	var pe *PeerError
+	if err = get(peer, "rateLimitedGroup", "key"); !errors.As(err, &pe) || !errors.Is(err, ErrPeerUnavailable) || pe.Retryable {
+		t.Fatalf("rejected request should be unavailable and not retryable, got %v", err)
+	}
+	if err = get(peer, "rateLimitedGroup", "key"); err == nil || limited.Stats.ServerRejects.Get() != 2 {
+		t.Fatalf("getter should back off from the peer without asking it, got %v and %d rejects", err, limited.Stats.ServerRejects.Get())
+	}
+
+	// load cap
+	capped := newTestGroup(t, "loadCappedGroup", 2<<10, getter)
+	srv = httptest.NewServer(NewHTTPPoolOpts("http://owner.invalid", &HTTPPoolOptions{
+		Admission: AdmissionOptions{MaxLoads: 2},
+	}))
+	t.Cleanup(srv.Close)
+	peer = &httpGetter{baseURL: srv.URL + defaultBasePath, client: http.DefaultClient}
+	if err = get(peer, "loadCappedGroup", "cached"); err != nil {
+		t.Fatal(err)
+	}
+	// requests waiting for a load in flight don't count
+	go capped.Get("slow")
+	go capped.Get("slow")
+	for capped.loads.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if err = get(peer, "loadCappedGroup", "cold"); err != nil {
+		t.Fatalf("keys to load should be served under the load cap, got %v", err)
+	}
+	go capped.Get("slow2")
+	for capped.originLoads.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if err = get(peer, "loadCappedGroup", "cached"); err != nil {
+		t.Fatalf("cached keys should be served at the load cap, got %v", err)
+	}
+	if err = get(peer, "loadCappedGroup", "colder"); !errors.Is(err, ErrPeerUnavailable) {
+		t.Fatalf("keys to load should be rejected at the load cap, got %v", err)
+	}
+
+	// queueing and load shedding
+	newTestGroup(t, "queuedGroup", 2<<10, getter)
+	pool := NewHTTPPoolOpts("http://owner.invalid", &HTTPPoolOptions{
+		Admission: AdmissionOptions{MaxConcurrent: 1, QueueTimeout: 20 * time.Millisecond},
+	})
+	srv = httptest.NewServer(pool)
+	t.Cleanup(srv.Close)
+	peer = &httpGetter{baseURL: srv.URL + defaultBasePath, client: http.DefaultClient}
+	go get(peer, "queuedGroup", "slow")
+	for len(pool.admission.slots) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	start := time.Now()
+	other := &httpGetter{baseURL: peer.baseURL, client: http.DefaultClient}
+	if err = get(other, "queuedGroup", "key"); !errors.Is(err, ErrPeerUnavailable) {
+		t.Fatalf("request queued past the timeout should be rejected, got %v", err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Fatalf("request should have queued before being rejected, took %v", d)
+	}
+
+	a := pool.admission
+	a.qmu.Lock()
+	a.minDelay, a.intervalEnd = 2*a.QueueTarget, time.Now()
+	a.qmu.Unlock()
+	a.observe(0)
+	if !a.shedding.Load() {
+		t.Fatalf("queue should shed once queueing stayed over target")
+	}
+	other = &httpGetter{baseURL: peer.baseURL, client: http.DefaultClient}
+	start = time.Now()
+	if err = get(other, "queuedGroup", "key"); !errors.Is(err, ErrPeerUnavailable) || time.Since(start) >= 20*time.Millisecond {
+		t.Fatalf("shedding queue should reject requests without queueing them, got %v", err)
+	}
+
+	// the rate limits of the least recently seen clients are forgotten
+	a = newAdmission(AdmissionOptions{ClientRate: 1, ClientBurst: 1})
+	a.limit(a.clients, "first", a.ClientRate, a.ClientBurst)
+	for i := 0; i < maxClientBuckets; i++ {
+		a.limit(a.clients, strconv.Itoa(i), a.ClientRate, a.ClientBurst)
+	}
+	if _, ok := a.clients.Get("first"); ok || a.clients.Len() != maxClientBuckets {
+		t.Fatalf("client buckets should be bounded to %d, got %d", maxClientBuckets, a.clients.Len())
+	}
+}
+
+func TestLeaseProtocol(t *testing.T) {
+	var loads atomic.Int64
+	owner := newTestGroup(t, "httpLeaseGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte("origin"), nil
+	}))
+	owner.SetLoadLease(LeaseOptions{TTL: time.Second})
+	owner.SetNegativeTTL(time.Minute)
+	srv := httptest.NewServer(NewHTTPPoolOpts("http://owner.invalid", &HTTPPoolOptions{OpenMembership: true}))
+	defer srv.Close()
+	peer := &httpGetter{baseURL: srv.URL + defaultBasePath, client: http.DefaultClient}
+	waiting := make(chan string, 1)
+	owner.leases.onWait = func(key string) {
+		select {
+		case waiting <- key:
+		default:
+		}
+	}
+
+	held, err := peer.acquire("httpLeaseGroup", "key", time.Second, time.Second)
+	if err != nil || held.id == 0 {
+		t.Fatalf("first caller should get the lease, got %+v, %v", held, err)
+	}
+	waited := make(chan leaseResult)
+	go func() {
+		res, err := peer.acquire("httpLeaseGroup", "key", time.Second, time.Second)
+		if err != nil {
+			t.Errorf("waiting for the lease failed: %v", err)
+		}
+		waited <- res
+	}()
+	<-waiting
+	if err = peer.release("httpLeaseGroup", "key", held.id, ByteView{b: []byte("loaded")}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if res := <-waited; !res.loaded || string(res.value.data()) != "loaded" {
+		t.Fatalf("waiting caller should get the holder's value, got %+v", res)
+	}
+	if v, err := owner.Get("key"); err != nil || v.String() != "loaded" || loads.Load() != 0 {
+		t.Fatalf("owner should cache the holder's value, got %q, %v", v.String(), err)
+	}
+
+	held, _ = peer.acquire("httpLeaseGroup", "missing", time.Second, time.Second)
+	go func() {
+		res, _ := peer.acquire("httpLeaseGroup", "missing", time.Second, time.Second)
+		waited <- res
+	}()
+	<-waiting
+	if err = peer.release("httpLeaseGroup", "missing", held.id, ByteView{}, fmt.Errorf("%w: missing", ErrNotFound)); err != nil {
+		t.Fatal(err)
+	}
+	if res := <-waited; !IsNotFound(res.err) || res.id != 0 {
+		t.Fatalf("waiting caller should get the holder's not-found error, got %+v", res)
+	}
+	if _, err := owner.Get("missing"); !IsNotFound(err) || loads.Load() != 0 {
+		t.Fatalf("owner should cache the holder's not-found error, got %v", err)
+	}
+
+	// only the holder of a live lease sets the value
+	if err = peer.release("httpLeaseGroup", "key", 12345, ByteView{b: []byte("forged")}, nil); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("release of a lease never granted should be refused, got %v", err)
+	}
+	held, _ = peer.acquire("httpLeaseGroup", "late", 20*time.Millisecond, time.Second)
+	time.Sleep(30 * time.Millisecond)
+	if err = peer.release("httpLeaseGroup", "late", held.id, ByteView{b: []byte("late")}, nil); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("release of an expired lease should be refused, got %v", err)
+	}
+	if v, err := owner.Get("key"); err != nil || v.String() != "loaded" {
+		t.Fatalf("refused releases should not change the owner's cache, got %q, %v", v.String(), err)
+	}
+	if _, ok := owner.mainCache.get("late"); ok {
+		t.Fatalf("refused releases should not change the owner's cache")
+	}
+
+	closed := httptest.NewServer(NewHTTPPool("http://owner.invalid"))
+	defer closed.Close()
+	anonymous := &httpGetter{baseURL: closed.URL + defaultBasePath, client: http.DefaultClient}
+	if _, err = anonymous.acquire("httpLeaseGroup", "key", time.Second, time.Second); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("leases should need authenticated peers, got %v", err)
+	}
+
+	newTestGroup(t, "httpNoLeaseGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	if _, err = peer.acquire("httpNoLeaseGroup", "key", time.Second, time.Second); !errors.Is(err, errBadRequest) {
+		t.Fatalf("leases of a group without them should be refused, got %v", err)
+	}
+
+	gone := &httpGetter{baseURL: "http://127.0.0.1:1" + defaultBasePath, client: http.DefaultClient}
+	if _, done, _ := owner.loadLeased(gone, "other"); done {
+		t.Fatalf("unreachable owner should leave the load to the caller")
+	}
+
+	unblock := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer hung.Close()
+	defer close(unblock)
+	stuck := &httpGetter{baseURL: hung.URL + defaultBasePath, client: http.DefaultClient}
+	start := time.Now()
+	if _, err = stuck.acquire("httpLeaseGroup", "key", time.Second, 10*time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("lease request to a hung owner should time out, got %v", err)
+	}
+	if d := time.Since(start); d > 10*time.Millisecond+2*leaseRequestMargin {
+		t.Fatalf("lease request should give up after its wait, took %v", d)
+	}
+}
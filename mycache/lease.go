@@ -0,0 +1,378 @@
+package mycache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	pb "mycache/mycachepb"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	defaultLeaseTTL = 5 * time.Second
+	// maxLeaseTTL bounds the leases peers ask for.
+	maxLeaseTTL = time.Minute
+	// leaseRequestMargin is how much longer than its wait a lease request
+	// may take, and leaseReleaseTimeout how long a release may take.
+	leaseRequestMargin  = time.Second
+	leaseReleaseTimeout = time.Second
+)
+
+// leasePath under basePath grants and releases load leases, see SetLoadLease.
+const leasePath = "_lease"
+
+// LeaseOptions configure the load leases of a group, see SetLoadLease.
+type LeaseOptions struct {
+	// TTL is how long a node holds the lease to load a key at most, so that
+	// a node dying while it loads doesn't hold up the others for long. It
+	// defaults to five seconds.
+	TTL time.Duration
+	// Wait is how long a node waits for the load of the lease holder before
+	// loading the key itself, it defaults to TTL.
+	Wait time.Duration
+}
+
+// SetLoadLease deduplicates the loads from the Getter across the cluster.
+// Before it loads a key, a node asks the key's owner for a short lease on
+// it. The first node to ask gets it and loads the key, the others wait for
+// its value, which the owner passes on and caches. If the holder finds the
+// key doesn't exist, the waiting nodes get its not-found error instead. If
+// the owner can't be reached, or the holder fails otherwise or is too slow,
+// nodes load the key themselves. Every peer must enable it for the group.
+// It must be called before the group is used.
+func (g *Group) SetLoadLease(opts LeaseOptions) {
+	if opts.TTL <= 0 {
+		opts.TTL = defaultLeaseTTL
+	}
+	if opts.Wait <= 0 {
+		opts.Wait = opts.TTL
+	}
+	g.leases = &leaseTable{LeaseOptions: opts, leases: make(map[string]*lease)}
+}
+
+// leaser grants the leases to load keys of a group.
+type leaser interface {
+	// acquire asks for the lease to load key for ttl, waiting up to wait
+	// for the load of another holder.
+	acquire(group, key string, ttl, wait time.Duration) (leaseResult, error)
+	// release ends the lease id, handing value to the nodes waiting for
+	// it, or err if the holder failed to load it. Not-found errors are
+	// handed on too, after other errors the waiting nodes take over.
+	release(group, key string, id uint64, value ByteView, err error) error
+}
+
+// leaseResult is the answer to a lease request.
+type leaseResult struct {
+	// id is the id of the granted lease, 0 if it was not granted.
+	id uint64
+	// loaded reports whether another holder loaded value, with the
+	// compression encoding.
+	loaded   bool
+	value    ByteView
+	encoding string
+	// err is the not-found error of another holder, which found that the
+	// key doesn't exist.
+	err error
+}
+
+// loadLeased loads key from the Getter under a lease from l. done is false
+// if the key is still to be loaded: no lease could be had from l and no
+// holder loaded the key in time.
+func (g *Group) loadLeased(l leaser, key string) (value ByteView, done bool, err error) {
+	start := time.Now()
+	res, err := l.acquire(g.name, key, g.leases.TTL, g.leases.Wait)
+	if err != nil {
+		log.Println("[GeeCache] failed to get lease", err)
+		return ByteView{}, false, nil
+	}
+	if res.loaded {
+		// the holder's value, passed on by the owner
+		g.loaded(key, LoadPeer, start, nil)
+		g.Stats.LeaseWaits.Add(1)
+		if value, err = g.received(res.value, res.encoding); err != nil {
+			return ByteView{}, false, nil
+		}
+		value.e = g.expireAt()
+		return g.populateCache(key, value), true, nil
+	}
+	if res.err != nil {
+		// the holder found the key doesn't exist, don't ask the Getter again
+		g.loaded(key, LoadPeer, start, res.err)
+		g.Stats.LeaseWaits.Add(1)
+		g.populateNegative(key, res.err)
+		return ByteView{}, true, res.err
+	}
+	if res.id == 0 {
+		return ByteView{}, false, nil
+	}
+
+	g.Stats.Leases.Add(1)
+	value, err = g.countLocal(g.getLocally(key))
+	if rerr := l.release(g.name, key, res.id, value, err); rerr != nil {
+		log.Println("[GeeCache] failed to release lease", rerr)
+	}
+	return value, true, err
+}
+
+// leaseTable holds the leases on the keys of a group this node owns.
+type leaseTable struct {
+	LeaseOptions
+
+	mu     sync.Mutex
+	leases map[string]*lease
+	// onWait, if set, is called with the key when a caller starts waiting
+	// for the holder of its lease.
+	onWait func(key string)
+}
+
+// lease is the lease to load a key.
+type lease struct {
+	id      uint64
+	expires time.Time
+	// done is closed on release, loaded and value, or the not-found err,
+	// are then set.
+	done   chan struct{}
+	loaded bool
+	value  ByteView
+	err    error
+}
+
+// acquire grants the lease on key unless another holder has it. Then it
+// waits for that holder's value or not-found error, taking over the lease
+// if the holder fails otherwise or its lease expires.
+func (t *leaseTable) acquire(_, key string, ttl, wait time.Duration) (leaseResult, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		now := time.Now()
+		t.mu.Lock()
+		l := t.leases[key]
+		if l == nil || !now.Before(l.expires) {
+			l = &lease{id: rand.Uint64() | 1, expires: now.Add(ttl), done: make(chan struct{})}
+			t.leases[key] = l
+			t.mu.Unlock()
+			return leaseResult{id: l.id}, nil
+		}
+		t.mu.Unlock()
+		if t.onWait != nil {
+			t.onWait(key)
+		}
+
+		until := l.expires
+		if deadline.Before(until) {
+			until = deadline
+		}
+		timer := time.NewTimer(until.Sub(now))
+		select {
+		case <-l.done:
+			timer.Stop()
+			if l.loaded {
+				return leaseResult{loaded: true, value: l.value}, nil
+			}
+			if l.err != nil {
+				return leaseResult{err: l.err}, nil
+			}
+		case <-timer.C:
+		}
+		if !time.Now().Before(deadline) {
+			return leaseResult{}, nil
+		}
+	}
+}
+
+// release ends the lease id on key, failing if it is no longer held.
+func (t *leaseTable) release(_, key string, id uint64, value ByteView, err error) error {
+	l := t.take(key, id)
+	if l == nil {
+		return errLeaseLost(key, id)
+	}
+	l.settle(value, err)
+	return nil
+}
+
+// take ends the lease id on key and returns it, or nil if it is no longer
+// held: it expired, and may have been taken over. The caller must settle it.
+func (t *leaseTable) take(key string, id uint64) *lease {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l := t.leases[key]
+	if l == nil || l.id != id || !time.Now().Before(l.expires) {
+		return nil
+	}
+	delete(t.leases, key)
+	return l
+}
+
+// settle hands the value the holder of l loaded, or its error, to the
+// nodes waiting for it.
+func (l *lease) settle(value ByteView, err error) {
+	l.loaded, l.value = err == nil, value
+	if IsNotFound(err) {
+		l.err = err
+	}
+	close(l.done)
+}
+
+// errLeaseLost is the error of a holder releasing the lease id on key after
+// it expired.
+func errLeaseLost(key string, id uint64) error {
+	return fmt.Errorf("%w: lease %d on %q is no longer held", ErrForbidden, id, key)
+}
+
+// serveLease grants or releases a lease on a key of a group this node owns,
+// if caller may write to the group.
+func (p *HTTPPool) serveLease(w http.ResponseWriter, r *http.Request, caller string) {
+	if !p.peerWritesAllowed() {
+		writeError(w, fmt.Errorf("%w: load leases need authenticated peers", ErrForbidden))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, fmt.Errorf("%w: method not allowed", errBadRequest))
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %v", errBadRequest, err))
+		return
+	}
+	req := &pb.LeaseRequest{}
+	if err = proto.Unmarshal(body, req); err != nil {
+		writeError(w, fmt.Errorf("%w: %v", errBadRequest, err))
+		return
+	}
+	if !p.allowed(caller, req.GetGroup(), PermWrite) {
+		writeError(w, fmt.Errorf("%w: %q may not write to group %s", ErrForbidden, caller, req.GetGroup()))
+		return
+	}
+	group := p.registry.Group(req.GetGroup())
+	if group == nil {
+		writeError(w, fmt.Errorf("%w: %s", ErrNoSuchGroup, req.GetGroup()))
+		return
+	}
+	if group.leases == nil {
+		writeError(w, fmt.Errorf("%w: group %s has no load leases", errBadRequest, group.name))
+		return
+	}
+	key := string(req.GetKey())
+
+	res := &pb.LeaseResponse{}
+	if id := req.GetRelease(); id != 0 {
+		// only the holder of a live lease may set what the key loads to
+		l := group.leases.take(key, id)
+		if l == nil {
+			writeError(w, errLeaseLost(key, id))
+			return
+		}
+		if !req.GetLoaded() {
+			loadErr := fromPB(req.GetError())
+			if IsNotFound(loadErr) {
+				group.populateNegative(key, loadErr)
+			}
+			l.settle(ByteView{}, loadErr)
+		} else if value, err := group.received(ByteView{b: req.GetValue()}, req.GetEncoding()); err != nil {
+			// the waiting nodes take over
+			l.settle(ByteView{}, err)
+			writeError(w, fmt.Errorf("%w: %v", errBadRequest, err))
+			return
+		} else {
+			// this node owns the key, keep the value for the peers to come
+			value.e = group.expireAt()
+			l.settle(group.populateCache(key, value), nil)
+		}
+	} else {
+		ttl := min(time.Duration(req.GetTtl()), maxLeaseTTL)
+		if ttl <= 0 {
+			ttl = group.leases.TTL
+		}
+		wait := min(time.Duration(req.GetWait()), ttl)
+		l, err := group.leases.acquire(group.name, key, ttl, wait)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		res.Id, res.Loaded = l.id, l.loaded
+		if l.err != nil {
+			res.Error = toPB(l.err)
+		}
+		if l.loaded {
+			res.Value = l.value.data()
+			if l.value.z != nil {
+				res.Encoding = l.value.z.Name()
+			}
+		}
+	}
+
+	body, err = proto.Marshal(res)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+// acquire asks the peer for the lease to load key of group.
+func (h *httpGetter) acquire(group, key string, ttl, wait time.Duration) (leaseResult, error) {
+	// the owner answers within wait, don't hang on one that doesn't
+	ctx, cancel := context.WithTimeout(context.Background(), wait+leaseRequestMargin)
+	defer cancel()
+	res := &pb.LeaseResponse{}
+	err := h.lease(ctx, &pb.LeaseRequest{Group: group, Key: []byte(key), Ttl: int64(ttl), Wait: int64(wait)}, res)
+	if err != nil {
+		return leaseResult{}, err
+	}
+	l := leaseResult{
+		id:       res.GetId(),
+		loaded:   res.GetLoaded(),
+		value:    ByteView{b: res.GetValue()},
+		encoding: res.GetEncoding(),
+	}
+	if e := res.GetError(); e != nil && e.GetCode() == pb.Code_NOT_FOUND {
+		l.err = fromPB(e)
+	}
+	return l, nil
+}
+
+// release releases the lease id the peer granted on key of group.
+func (h *httpGetter) release(group, key string, id uint64, value ByteView, err error) error {
+	req := &pb.LeaseRequest{Group: group, Key: []byte(key), Release: id, Loaded: err == nil}
+	if err != nil {
+		req.Error = toPB(err)
+	} else {
+		req.Value = value.data()
+		if value.z != nil {
+			req.Encoding = value.z.Name()
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), leaseReleaseTimeout)
+	defer cancel()
+	return h.lease(ctx, req, &pb.LeaseResponse{})
+}
+
+// lease sends a lease request to the peer.
+func (h *httpGetter) lease(ctx context.Context, in *pb.LeaseRequest, out *pb.LeaseResponse) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.baseURL+leasePath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	res, err := send(h.client, h.auth, req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return responseError(res)
+	}
+	return readResponse(res, out)
+}
@@ -2,20 +2,23 @@ package lru
 
 import "container/list"
 
-// Cache is a LRU cache, It is not safe for concurrent access.
-type Cache struct {
+// TypedCache is a LRU cache of values of type V by keys of type K, bounded
+// by the total size of its entries. It is not safe for concurrent access.
+type TypedCache[K comparable, V any] struct {
 	maxBytes int64
 	nbytes   int64
-	ll       *list.List
-	cache    map[string]*list.Element
+	// size returns the size of an entry.
+	size  func(key K, value V) int64
+	ll    *list.List
+	cache map[K]*list.Element
 	// OnEvicted is an optional function that is executed when an entry is purged.
-	OnEvicted func(key string, value Value)
+	OnEvicted func(key K, value V)
 }
 
 // entry represents a key-value pair in the LRU cache.
-type entry struct {
-	key   string
-	value Value
+type entry[K comparable, V any] struct {
+	key   K
+	value V
 }
 
 // Value is an interface that represents the value stored in the cache.
@@ -24,23 +27,41 @@ type Value interface {
 	Len() int
 }
 
-// New creates a new LRU cache with the specified maximum number of bytes and an optional eviction callback function.
+// Cache is a LRU cache of Values by string keys, see New.
+type Cache = TypedCache[string, Value]
+
+// New creates a new LRU cache of Values with the specified maximum number of bytes and an optional eviction callback function.
+// Entries are sized by the length of their key plus the Len of their value.
 func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
-	return &Cache{
+	return NewTypedCache(maxBytes, func(key string, value Value) int64 {
+		return int64(len(key)) + int64(value.Len())
+	}, onEvicted)
+}
+
+// NewTypedCache creates a new LRU cache holding entries of up to maxBytes in
+// total, as sized by size, and an optional eviction callback function.
+// A zero maxBytes means no limit. A nil size counts every entry as 1, which
+// makes maxBytes the maximum number of entries.
+func NewTypedCache[K comparable, V any](maxBytes int64, size func(key K, value V) int64, onEvicted func(K, V)) *TypedCache[K, V] {
+	if size == nil {
+		size = func(K, V) int64 { return 1 }
+	}
+	return &TypedCache[K, V]{
 		maxBytes:  maxBytes,
+		size:      size,
 		ll:        list.New(),
-		cache:     map[string]*list.Element{},
+		cache:     map[K]*list.Element{},
 		OnEvicted: onEvicted,
 	}
 }
 
 // Get looks up a key's value in the cache.
 // If the key exists, the corresponding entry is moved to the front of the cache (most recently used).
-// Returns the value and true if the key exists, or nil and false otherwise.
-func (c *Cache) Get(key string) (value Value, ok bool) {
+// Returns the value and true if the key exists, or the zero value and false otherwise.
+func (c *TypedCache[K, V]) Get(key K) (value V, ok bool) {
 	if ele, ok := c.cache[key]; ok {
 		c.ll.MoveToFront(ele)
-		kv := ele.Value.(*entry)
+		kv := ele.Value.(*entry[K, V])
 		return kv.value, true
 	}
 	return
@@ -49,29 +70,42 @@ func (c *Cache) Get(key string) (value Value, ok bool) {
 // RemoveOldest removes the oldest entry from the cache.
 // The oldest entry is the one at the back of the cache (least recently used).
 // If an eviction callback function is specified, it is executed with the key and value of the removed entry.
-func (c *Cache) RemoveOldest() {
+func (c *TypedCache[K, V]) RemoveOldest() {
 	ele := c.ll.Back()
 	if ele != nil {
-		c.ll.Remove(ele)
-		kv := ele.Value.(*entry)
-		delete(c.cache, kv.key)
-		c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
-		if c.OnEvicted != nil {
-			c.OnEvicted(kv.key, kv.value)
-		}
+		c.removeElement(ele)
 	}
 }
 
-func (c *Cache) Add(key string, value Value) {
+func (c *TypedCache[K, V]) removeElement(ele *list.Element) {
+	c.ll.Remove(ele)
+	kv := ele.Value.(*entry[K, V])
+	delete(c.cache, kv.key)
+	c.nbytes -= c.size(kv.key, kv.value)
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value)
+	}
+}
+
+// Remove removes the entry for key from the cache, if any.
+// If an eviction callback function is specified, it is executed with the key and value of the removed entry.
+func (c *TypedCache[K, V]) Remove(key K) {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele)
+	}
+}
+
+// Add adds a value to the cache, evicting the oldest entries while the cache is larger than maxBytes.
+func (c *TypedCache[K, V]) Add(key K, value V) {
 	if ele, ok := c.cache[key]; ok {
 		c.ll.MoveToFront(ele)
-		kv := ele.Value.(*entry)
-		c.nbytes += int64(value.Len()) - int64(kv.value.Len())
+		kv := ele.Value.(*entry[K, V])
+		c.nbytes += c.size(key, value) - c.size(key, kv.value)
 		kv.value = value
 	} else {
-		ele = c.ll.PushFront(&entry{key, value})
+		ele = c.ll.PushFront(&entry[K, V]{key, value})
 		c.cache[key] = ele
-		c.nbytes += int64(len(key)) + int64(value.Len())
+		c.nbytes += c.size(key, value)
 	}
 
 	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
@@ -79,6 +113,18 @@ func (c *Cache) Add(key string, value Value) {
 	}
 }
 
-func (c *Cache) Len() int {
+// Range calls fn for each entry from the most to the least recently used,
+// stopping early if fn returns false. It does not change the order of the entries.
+func (c *TypedCache[K, V]) Range(fn func(key K, value V) bool) {
+	for ele := c.ll.Front(); ele != nil; ele = ele.Next() {
+		kv := ele.Value.(*entry[K, V])
+		if !fn(kv.key, kv.value) {
+			return
+		}
+	}
+}
+
+// Len returns the number of entries in the cache.
+func (c *TypedCache[K, V]) Len() int {
 	return c.ll.Len()
 }
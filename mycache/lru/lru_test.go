@@ -74,3 +74,51 @@ func TestOnEvicted(t *testing.T) {
 		t.Fatalf("call onEvicted failed, expect keys equals to %s,Got %s", expect, keys)
 	}
 }
+
+func TestRange(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.Add("key1", String("1"))
+	lru.Add("key2", String("2"))
+	lru.Add("key3", String("3"))
+	lru.Get("key1")
+
+	keys := make([]string, 0)
+	lru.Range(func(key string, value Value) bool {
+		keys = append(keys, key)
+		return len(keys) < 2
+	})
+
+	expect := []string{"key1", "key3"}
+	if !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("range should visit most recently used first, expect %s, got %s", expect, keys)
+	}
+}
+
+func TestCache(t *testing.T) {
+	var evicted []int
+	lru := NewTypedCache(int64(10), func(key int, value []byte) int64 {
+		return int64(len(value))
+	}, func(key int, value []byte) {
+		evicted = append(evicted, key)
+	})
+	lru.Add(1, []byte("1234"))
+	lru.Add(2, []byte("5678"))
+	if v, ok := lru.Get(1); !ok || string(v) != "1234" {
+		t.Fatalf("cache hit 1=1234 failed")
+	}
+	lru.Add(3, []byte("90"))
+	lru.Add(4, []byte("a"))
+
+	expect := []int{2}
+	if !reflect.DeepEqual(expect, evicted) || lru.Len() != 3 {
+		t.Fatalf("entries should be sized by the size func, expect evicted %v, got %v", expect, evicted)
+	}
+
+	counted := NewTypedCache[string, int](2, nil, nil)
+	counted.Add("a", 1)
+	counted.Add("b", 2)
+	counted.Add("c", 3)
+	if _, ok := counted.Get("a"); ok || counted.Len() != 2 {
+		t.Fatalf("a nil size should count entries, got %d entries", counted.Len())
+	}
+}
@@ -0,0 +1,575 @@
+// Package msgpack encodes Go values in the MessagePack format.
+//
+// It covers the types cached values are made of: booleans, integers,
+// floats, strings, byte slices, slices, arrays, maps, pointers and structs.
+// Structs are encoded as maps keyed by field name, or by the name in a
+// `msgpack:"name"` tag, and fields tagged `msgpack:"-"` are skipped. Types
+// implementing encoding.BinaryMarshaler, such as time.Time, are encoded as
+// binary. Extension types are not supported.
+package msgpack
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+var (
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+// Marshal returns the MessagePack encoding of v.
+func Marshal(v any) ([]byte, error) {
+	e := &encoder{}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.buf = append(e.buf, 0xc0)
+		return nil
+	}
+	if v.Type().Implements(binaryMarshalerType) && !(v.Kind() == reflect.Pointer && v.IsNil()) {
+		b, err := v.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return err
+		}
+		e.bytes(b)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, 0xc3)
+		} else {
+			e.buf = append(e.buf, 0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.uint(v.Uint())
+	case reflect.Float32:
+		e.buf = append(e.buf, 0xca)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.buf = append(e.buf, 0xcb)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.str(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.bytes(v.Bytes())
+			return nil
+		}
+		fallthrough
+	case reflect.Array:
+		e.header(v.Len(), 0x90, 0xdc, 0xdd)
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0xc0)
+			return nil
+		}
+		e.header(v.Len(), 0x80, 0xde, 0xdf)
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := e.encode(iter.Key()); err != nil {
+				return err
+			}
+			if err := e.encode(iter.Value()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fields := structFields(v.Type())
+		e.header(len(fields), 0x80, 0xde, 0xdf)
+		for _, f := range fields {
+			e.str(f.name)
+			if err := e.encode(v.Field(f.index)); err != nil {
+				return err
+			}
+		}
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0xc0)
+			return nil
+		}
+		return e.encode(v.Elem())
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func (e *encoder) int(n int64) {
+	switch {
+	case n >= 0:
+		e.uint(uint64(n))
+	case n >= -32:
+		e.buf = append(e.buf, byte(n))
+	case n >= math.MinInt8:
+		e.buf = append(e.buf, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xd1), uint16(n))
+	case n >= math.MinInt32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xd2), uint32(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xd3), uint64(n))
+	}
+}
+
+func (e *encoder) uint(n uint64) {
+	switch {
+	case n <= 0x7f:
+		e.buf = append(e.buf, byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xcc, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xcd), uint16(n))
+	case n <= math.MaxUint32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xce), uint32(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xcf), n)
+	}
+}
+
+func (e *encoder) str(s string) {
+	switch n := len(s); {
+	case n <= 31:
+		e.buf = append(e.buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xda), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xdb), uint32(n))
+	}
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) bytes(b []byte) {
+	switch n := len(b); {
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xc5), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xc6), uint32(n))
+	}
+	e.buf = append(e.buf, b...)
+}
+
+// header writes the length of an array or map: fix is the fix format, with
+// room for 15 elements, and b16 and b32 the 16 and 32 bit formats.
+func (e *encoder) header(n int, fix, b16, b32 byte) {
+	switch {
+	case n <= 15:
+		e.buf = append(e.buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, b16), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, b32), uint32(n))
+	}
+}
+
+type field struct {
+	name  string
+	index int
+}
+
+// structFields returns the encoded fields of a struct type.
+func structFields(t reflect.Type) []field {
+	fields := make([]field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag, _, _ := strings.Cut(f.Tag.Get("msgpack"), ","); tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		fields = append(fields, field{name, i})
+	}
+	return fields
+}
+
+// Unmarshal decodes the MessagePack data into the value pointed to by v.
+// Values decoded into an interface are nil, bool, int64, uint64, float32,
+// float64, string, []byte, []any or map[any]any.
+func Unmarshal(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errors.New("msgpack: Unmarshal needs a non-nil pointer")
+	}
+	d := &decoder{data: data}
+	if err := d.decode(rv.Elem()); err != nil {
+		return err
+	}
+	if d.off != len(d.data) {
+		return fmt.Errorf("msgpack: %d trailing bytes", len(d.data)-d.off)
+	}
+	return nil
+}
+
+var errShort = errors.New("msgpack: unexpected end of data")
+
+// maxDepth bounds the nesting of arrays and maps, so that deeply nested
+// data can't exhaust the stack.
+const maxDepth = 10000
+
+var errDepth = fmt.Errorf("msgpack: nested deeper than %d", maxDepth)
+
+type decoder struct {
+	data []byte
+	off  int
+	// depth is the number of arrays and maps being decoded.
+	depth int
+}
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.off < n {
+		return nil, errShort
+	}
+	b := d.data[d.off : d.off+n]
+	d.off += n
+	return b, nil
+}
+
+func (d *decoder) uintN(n int) (uint64, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// value reads the next value as one of the types documented on Unmarshal.
+func (d *decoder) value() (any, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.mapValue(int(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return d.arrayValue(int(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		s, err := d.next(int(c & 0x1f))
+		return string(s), err
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uintN(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.next(int(n))
+		return append([]byte(nil), s...), err
+	case 0xca:
+		n, err := d.uintN(4)
+		return math.Float32frombits(uint32(n)), err
+	case 0xcb:
+		n, err := d.uintN(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return d.uintN(1 << (c - 0xcc))
+	case 0xd0:
+		n, err := d.uintN(1)
+		return int64(int8(n)), err
+	case 0xd1:
+		n, err := d.uintN(2)
+		return int64(int16(n)), err
+	case 0xd2:
+		n, err := d.uintN(4)
+		return int64(int32(n)), err
+	case 0xd3:
+		n, err := d.uintN(8)
+		return int64(n), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uintN(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.next(int(n))
+		return string(s), err
+	case 0xdc, 0xdd:
+		n, err := d.uintN(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayValue(int(n))
+	case 0xde, 0xdf:
+		n, err := d.uintN(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapValue(int(n))
+	}
+	return nil, fmt.Errorf("msgpack: unsupported format 0x%02x", c)
+}
+
+func (d *decoder) arrayValue(n int) (any, error) {
+	// every element takes at least one byte
+	if n > len(d.data)-d.off {
+		return nil, errShort
+	}
+	if d.depth++; d.depth > maxDepth {
+		return nil, errDepth
+	}
+	defer func() { d.depth-- }()
+	a := make([]any, n)
+	for i := range a {
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		a[i] = v
+	}
+	return a, nil
+}
+
+func (d *decoder) mapValue(n int) (any, error) {
+	if 2*n > len(d.data)-d.off {
+		return nil, errShort
+	}
+	if d.depth++; d.depth > maxDepth {
+		return nil, errDepth
+	}
+	defer func() { d.depth-- }()
+	m := make(map[any]any, n)
+	for i := 0; i < n; i++ {
+		k, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := k.([]byte); ok {
+			k = string(k.([]byte))
+		}
+		if _, ok := k.([]any); ok {
+			return nil, errors.New("msgpack: array map key")
+		}
+		if _, ok := k.(map[any]any); ok {
+			return nil, errors.New("msgpack: map map key")
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+// decode reads the next value into v.
+func (d *decoder) decode(v reflect.Value) error {
+	x, err := d.value()
+	if err != nil {
+		return err
+	}
+	return assign(v, x)
+}
+
+// assign stores a decoded value into v, converting it to the type of v.
+func assign(v reflect.Value, x any) error {
+	if x == nil {
+		v.SetZero()
+		return nil
+	}
+	if v.Kind() != reflect.Pointer && v.CanAddr() && v.Addr().Type().Implements(binaryUnmarshalerType) {
+		b, ok := x.([]byte)
+		if !ok {
+			return typeError(x, v.Type())
+		}
+		return v.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(b)
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return typeError(x, v.Type())
+		}
+		v.Set(reflect.ValueOf(x))
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return assign(v.Elem(), x)
+	case reflect.Bool:
+		b, ok := x.(bool)
+		if !ok {
+			return typeError(x, v.Type())
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		switch x := x.(type) {
+		case int64:
+			n = x
+		case uint64:
+			if x > math.MaxInt64 {
+				return typeError(x, v.Type())
+			}
+			n = int64(x)
+		default:
+			return typeError(x, v.Type())
+		}
+		if v.OverflowInt(n) {
+			return typeError(x, v.Type())
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var n uint64
+		switch x := x.(type) {
+		case uint64:
+			n = x
+		case int64:
+			if x < 0 {
+				return typeError(x, v.Type())
+			}
+			n = uint64(x)
+		default:
+			return typeError(x, v.Type())
+		}
+		if v.OverflowUint(n) {
+			return typeError(x, v.Type())
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		switch x := x.(type) {
+		case float32:
+			v.SetFloat(float64(x))
+		case float64:
+			v.SetFloat(x)
+		case int64:
+			v.SetFloat(float64(x))
+		case uint64:
+			v.SetFloat(float64(x))
+		default:
+			return typeError(x, v.Type())
+		}
+	case reflect.String:
+		switch x := x.(type) {
+		case string:
+			v.SetString(x)
+		case []byte:
+			v.SetString(string(x))
+		default:
+			return typeError(x, v.Type())
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			switch x := x.(type) {
+			case []byte:
+				v.SetBytes(x)
+				return nil
+			case string:
+				v.SetBytes([]byte(x))
+				return nil
+			}
+		}
+		a, ok := x.([]any)
+		if !ok {
+			return typeError(x, v.Type())
+		}
+		s := reflect.MakeSlice(v.Type(), len(a), len(a))
+		for i := range a {
+			if err := assign(s.Index(i), a[i]); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Array:
+		a, ok := x.([]any)
+		if !ok || len(a) != v.Len() {
+			return typeError(x, v.Type())
+		}
+		for i := range a {
+			if err := assign(v.Index(i), a[i]); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		m, ok := x.(map[any]any)
+		if !ok {
+			return typeError(x, v.Type())
+		}
+		out := reflect.MakeMapWithSize(v.Type(), len(m))
+		for k, e := range m {
+			kv := reflect.New(v.Type().Key()).Elem()
+			if err := assign(kv, k); err != nil {
+				return err
+			}
+			ev := reflect.New(v.Type().Elem()).Elem()
+			if err := assign(ev, e); err != nil {
+				return err
+			}
+			out.SetMapIndex(kv, ev)
+		}
+		v.Set(out)
+	case reflect.Struct:
+		m, ok := x.(map[any]any)
+		if !ok {
+			return typeError(x, v.Type())
+		}
+		v.SetZero()
+		for _, f := range structFields(v.Type()) {
+			if e, ok := m[f.name]; ok {
+				if err := assign(v.Field(f.index), e); err != nil {
+					return err
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func typeError(x any, t reflect.Type) error {
+	return fmt.Errorf("msgpack: cannot decode %T into %s", x, t)
+}
@@ -0,0 +1,119 @@
+package msgpack
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarshalFormat(t *testing.T) {
+	testCases := []struct {
+		v    any
+		want []byte
+	}{
+		{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{1, []byte{0x01}},
+		{-1, []byte{0xff}},
+		{-33, []byte{0xd0, 0xdf}},
+		{256, []byte{0xcd, 0x01, 0x00}},
+		{uint32(70000), []byte{0xce, 0x00, 0x01, 0x11, 0x70}},
+		{1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"", []byte{0xa0}},
+		{"hi", []byte{0xa2, 'h', 'i'}},
+		{[]byte{1, 2}, []byte{0xc4, 0x02, 0x01, 0x02}},
+		{[]int{1, 2}, []byte{0x92, 0x01, 0x02}},
+		{map[string]int{"a": 1}, []byte{0x81, 0xa1, 'a', 0x01}},
+		{strings.Repeat("x", 32), append([]byte{0xd9, 32}, strings.Repeat("x", 32)...)},
+	}
+	for _, tc := range testCases {
+		got, err := Marshal(tc.v)
+		if err != nil || !bytes.Equal(got, tc.want) {
+			t.Errorf("Marshal(%#v) = % x, %v, want % x", tc.v, got, err, tc.want)
+		}
+	}
+}
+
+type inner struct {
+	Tags []string
+}
+
+type record struct {
+	Name    string
+	Score   int64 `msgpack:"score"`
+	Ratio   float64
+	Counts  map[string]uint16
+	Raw     []byte
+	Inner   *inner
+	Items   []inner
+	Created time.Time
+	Secret  string `msgpack:"-"`
+	private int
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := record{
+		Name:    "Tom",
+		Score:   -70000,
+		Ratio:   0.25,
+		Counts:  map[string]uint16{"a": 1, "b": 65535},
+		Raw:     []byte{0, 1, 2},
+		Inner:   &inner{Tags: []string{"x", "y"}},
+		Items:   []inner{{}, {Tags: []string{strings.Repeat("z", 300)}}},
+		Created: time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+		Secret:  "hidden",
+		private: 1,
+	}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out record
+	if err = Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	in.Secret, in.private = "", 0
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip mismatch:\n got %+v\nwant %+v", out, in)
+	}
+
+	var generic any
+	if err = Unmarshal(data, &generic); err != nil {
+		t.Fatal(err)
+	}
+	if m := generic.(map[any]any); m["Name"] != "Tom" || m["score"] != int64(-70000) {
+		t.Fatalf("unexpected generic decoding %v", generic)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	data, _ := Marshal(record{Name: "Tom"})
+	var out record
+	for i := 0; i < len(data); i++ {
+		if err := Unmarshal(data[:i], &out); err == nil {
+			t.Fatalf("truncated data of %d bytes should fail", i)
+		}
+	}
+	if err := Unmarshal(append(data, 0x01), &out); err == nil {
+		t.Fatalf("trailing data should fail")
+	}
+	var n int8
+	if err := Unmarshal([]byte{0xcd, 0x01, 0x00}, &n); err == nil {
+		t.Fatalf("256 should not fit in an int8")
+	}
+	// a huge array header must not allocate before the data runs out
+	if err := Unmarshal([]byte{0xdd, 0xff, 0xff, 0xff, 0xff}, &out.Items); err == nil {
+		t.Fatalf("array longer than the data should fail")
+	}
+	// arrays of one array nested past maxDepth
+	nested := bytes.Repeat([]byte{0x91}, maxDepth+1)
+	var v any
+	if err := Unmarshal(append(nested, 0xc0), &v); err == nil {
+		t.Fatalf("data nested deeper than %d should fail", maxDepth)
+	}
+	if err := Unmarshal(append(nested[1:], 0xc0), &v); err != nil {
+		t.Fatalf("data nested %d deep should decode, got %v", maxDepth, err)
+	}
+}
@@ -1,11 +1,15 @@
 package mycache
 
 import (
+	"context"
 	"fmt"
+	"mycache/diskcache"
 	pb "mycache/mycachepb"
 	"mycache/singleflight"
 	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Getter interface {
@@ -18,48 +22,172 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+// A StringGetterFunc loads values as strings. Groups store them as
+// string-backed views without copying them.
+type StringGetterFunc func(key string) (string, error)
+
+func (f StringGetterFunc) Get(key string) ([]byte, error) {
+	s, err := f(key)
+	return []byte(s), err
+}
+
+func (f StringGetterFunc) getView(key string) (ByteView, error) {
+	s, err := f(key)
+	return ByteView{s: s}, err
+}
+
+// viewGetter is implemented by getters that load values as views, which the
+// group stores as is instead of copying the bytes returned by Get.
+type viewGetter interface {
+	getView(key string) (ByteView, error)
+}
+
 type Group struct {
 	name      string
 	getter    Getter
 	mainCache cache
 	hotCache  cache
 	peers     PeerPicker
-	// use singleflight.Group to make sure
+	// ttl is how long loaded values stay in the cache, zero means forever.
+	ttl time.Duration
+	// maxStale is how long past expiry a value is served while it is
+	// refreshed in the background, zero disables it.
+	maxStale time.Duration
+	// refreshAhead is how long before expiry a read value is refreshed in
+	// the background, zero disables it.
+	refreshAhead time.Duration
+	// refreshAheadMinHits is how many cache hits a value needs before it
+	// is refreshed ahead, hits counts them.
+	refreshAheadMinHits int
+	hits                hitCounter
+	// refreshing holds the keys being refreshed in the background.
+	refreshing sync.Map
+	// negCache holds not-found errors for negativeTTL, zero disables it.
+	negCache    negativeCache
+	negativeTTL time.Duration
+	// use singleflight.TypedGroup to make sure
 	// that each key is fetched once at the same
-	loader *singleflight.Group
+	loader *singleflight.TypedGroup[string, ByteView]
+	// loads is the number of in-flight load calls, Drain waits for it to reach zero.
+	loads atomic.Int64
+	// originLoads is the number of loads from the getter in flight, see
+	// AdmissionOptions.MaxLoads.
+	originLoads atomic.Int64
+	// filter, if set, holds every valid key, see EnableKeyFilter.
+	filter atomic.Pointer[keyFilter]
+	// hedge, if set, hedges slow loads from peers with local ones.
+	hedge *hedger
+	// retry says how loads from peers are retried.
+	retry RetryOptions
+	// bulkhead, if set, limits the concurrent loads from the getter.
+	bulkhead *bulkhead
+	// leases, if set, holds the load leases on the keys this node owns,
+	// and loads from the getter take a lease from the key's owner.
+	leases *leaseTable
+	// statsHook, if set, is called periodically with the group's stats.
+	statsHook *statsHook
+	// setup holds the work of options with side effects, see deferSetup.
+	setup []func() error
+	// registry is the registry the group is registered in.
+	registry *Registry
+	// hooks are called on the events of the group.
+	hooks Hooks
+	// done is closed when the group is deleted, stopping its background work.
+	done chan struct{}
+	// onClose is called when the group is deleted, e.g. to drop the
+	// decoded values of a TypedGroup.
+	onClose []func()
+
+	// Stats are statistics on the group.
+	Stats Stats
 }
 
-var (
-	mu     sync.RWMutex
-	groups = make(map[string]*Group)
-)
+// defaultCacheBytes is the size of the main cache of a group, see WithCacheBytes.
+const defaultCacheBytes = 64 << 20
+
+// drainPollInterval is how often Drain checks for in-flight loads.
+const drainPollInterval = 10 * time.Millisecond
 
-// NewGroup creates a new cache group with the specified name, cache size, and getter function.
-// It panics if the getter function is nil.
-// The cache group is stored in the global groups map and returned.
-func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
+// NewGroup creates a new cache group with the specified name and getter
+// function, configured by opts, and registers it under name in
+// DefaultRegistry, or the registry given WithRegistry, for peers to reach
+// it. It fails with ErrGroupExists if the registry already has a group of
+// that name, see DeleteGroup. It panics if the getter function is nil.
+func NewGroup(name string, getter Getter, opts ...Option) (*Group, error) {
 	if getter == nil {
 		panic("nil getter")
 	}
 
-	mu.Lock()
-	defer mu.Unlock()
 	g := &Group{
 		name:      name,
 		getter:    getter,
-		mainCache: cache{cacheBytes: cacheBytes},
-		loader:    &singleflight.Group{},
+		mainCache: cache{cacheBytes: defaultCacheBytes},
+		loader:    &singleflight.TypedGroup[string, ByteView]{},
+		registry:  DefaultRegistry,
+		done:      make(chan struct{}),
+		// read once and hit once
+		refreshAheadMinHits: 2,
+	}
+	for _, opt := range opts {
+		if err := opt(g); err != nil {
+			g.close()
+			return nil, err
+		}
+	}
+	// the name is reserved before the options with side effects run, they
+	// would open the disk cache of a group of the same name
+	if err := g.registry.reserve(name); err != nil {
+		g.close()
+		return nil, err
+	}
+	// they run unlocked, building a key filter or opening a disk cache may take a while
+	for _, setup := range g.setup {
+		if err := setup(); err != nil {
+			g.registry.release(name)
+			g.close()
+			return nil, err
+		}
 	}
-	groups[name] = g
-	return g
+	g.setup = nil
+	g.registry.add(g)
+	if g.statsHook != nil {
+		go g.reportStats(g.statsHook.interval, g.statsHook.fn)
+	}
+	return g, nil
 }
 
-// GetGroup returns the group with the specified name.
+// GetGroup returns the group of DefaultRegistry with the specified name.
 func GetGroup(name string) *Group {
-	mu.RLock()
-	g := groups[name]
-	mu.RUnlock()
-	return g
+	return DefaultRegistry.Group(name)
+}
+
+// DeleteGroup deletes the group of DefaultRegistry with the specified name,
+// see Registry.Delete.
+func DeleteGroup(name string) bool {
+	return DefaultRegistry.Delete(name)
+}
+
+// close stops the background work of the group and drops its caches.
+func (g *Group) close() {
+	close(g.done)
+	g.mainCache.close()
+	g.hotCache.close()
+	g.negCache.close()
+	g.hits.close()
+	g.filter.Store(nil)
+	for _, f := range g.onClose {
+		f()
+	}
+}
+
+// deleted reports whether the group was deleted.
+func (g *Group) deleted() bool {
+	select {
+	case <-g.done:
+		return true
+	default:
+		return false
+	}
 }
 
 // RegisterPeers registers the PeerPicker for the Group.
@@ -71,22 +199,139 @@ func (g *Group) RegisterPeers(peers PeerPicker) {
 	g.peers = peers
 }
 
+// SetTTL sets how long values loaded by the Group stay in its cache.
+// A zero ttl, the default, keeps values until they are evicted.
+func (g *Group) SetTTL(ttl time.Duration) {
+	g.ttl = ttl
+}
+
+// SetStaleWhileRevalidate makes Get return values that expired less than
+// maxStale ago instead of loading them, while one background load refreshes
+// them. If the refresh fails the stale value keeps being served until it is
+// maxStale past expiry. A zero maxStale, the default, disables it.
+func (g *Group) SetStaleWhileRevalidate(maxStale time.Duration) {
+	g.maxStale = maxStale
+	g.mainCache.maxStale = maxStale
+	g.hotCache.maxStale = maxStale
+}
+
+// SetRefreshAhead makes Get refresh frequently hit values read within
+// window of their expiry in the background, so that keys in use don't
+// expire. Values hit less often than SetRefreshAheadMinHits are left to
+// expire. A zero window, the default, disables it.
+func (g *Group) SetRefreshAhead(window time.Duration) {
+	g.refreshAhead = window
+}
+
+// SetRefreshAheadMinHits sets how many times a value must be found in the
+// cache since it was loaded to be refreshed ahead, see SetRefreshAhead.
+// It defaults to 2, so that keys read once are never refreshed.
+func (g *Group) SetRefreshAheadMinHits(n int) {
+	g.refreshAheadMinHits = n
+}
+
+// SetNegativeTTL makes the Group remember not-found errors, see IsNotFound,
+// for ttl so that requests for missing keys don't all reach the Getter.
+// The cached error is returned to callers as is. A zero ttl, the default,
+// disables negative caching.
+func (g *Group) SetNegativeTTL(ttl time.Duration) {
+	g.negativeTTL = ttl
+}
+
+// EnableDiskCache adds a second cache tier in dir holding up to maxBytes of
+// values. Values evicted from memory are demoted to it, and memory misses are
+// looked up in it before the value is loaded. It must be called before the
+// group is used, and dir must not be shared with another group.
+func (g *Group) EnableDiskCache(dir string, maxBytes int64) error {
+	d, err := diskcache.Open(dir, maxBytes)
+	if err != nil {
+		return err
+	}
+	g.mainCache.disk = d
+	return nil
+}
+
+// SetCompression makes the group store values of at least threshold bytes
+// compressed with c, and send them to peers that accept c compressed.
+// Values that don't shrink are stored as is. It must be called before the
+// group is used. A nil c, the default, disables compression.
+func (g *Group) SetCompression(c Compressor, threshold int) {
+	for _, cc := range []*cache{&g.mainCache, &g.hotCache} {
+		cc.compressor = c
+		cc.minCompress = threshold
+		cc.stats = &g.Stats
+	}
+}
+
 // Get retrieves the value for the given key from the cache.
 // If the key is empty, it returns an empty ByteView and an error indicating that the key is required.
 // If the value is found in the cache, it returns the value (ByteView) and nil error.
 // If the value is not found in the cache, it calls the load method to load the value and returns it.
 func (g *Group) Get(key string) (ByteView, error) {
+	v, err := g.get(key)
+	if err != nil {
+		return ByteView{}, err
+	}
+	return v.decompress()
+}
+
+// get is Get returning the value as stored, possibly compressed.
+func (g *Group) get(key string) (ByteView, error) {
+	g.Stats.Gets.Add(1)
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key is required")
 	}
 
-	if v, ok := g.mainCache.get(key); ok {
+	if v, ok := g.lookupCache(key); ok {
+		now := time.Now()
+		if g.hooks.OnHit != nil {
+			g.hooks.OnHit(key)
+		}
+		if v.expired(now) {
+			log.Println("[GeeCache] stale hit")
+			g.Stats.StaleHits.Add(1)
+			g.refresh(key)
+			return v, nil
+		}
 		log.Println("[GeeCache] hit")
+		g.Stats.CacheHits.Add(1)
+		if g.refreshAhead > 0 && !v.e.IsZero() {
+			if hits := g.hits.hit(key); v.e.Sub(now) < g.refreshAhead && hits >= g.refreshAheadMinHits {
+				g.refresh(key)
+			}
+		}
 		return v, nil
 	}
+	if err, ok := g.negCache.get(key); ok {
+		log.Println("[GeeCache] negative hit")
+		g.Stats.NegativeHits.Add(1)
+		return ByteView{}, err
+	}
+	if g.filtered(key) {
+		g.Stats.FilterRejects.Add(1)
+		return ByteView{}, errFiltered
+	}
 	return g.load(key)
 }
 
+// cached reports whether key is in the cache, fresh or stale, so that
+// getting it doesn't load it.
+func (g *Group) cached(key string) bool {
+	_, ok := g.lookupCache(key)
+	return ok
+}
+
+// lookupCache looks key up in the main cache, then in the hot cache.
+func (g *Group) lookupCache(key string) (ByteView, bool) {
+	if v, ok := g.mainCache.get(key); ok {
+		return v, true
+	}
+	if g.hotCache.cacheBytes > 0 {
+		return g.hotCache.get(key)
+	}
+	return ByteView{}, false
+}
+
 // load loads the value for the given key from the cache.
 // If the value is not found in the cache, it tries to retrieve it from the peers.
 // If the peers are available and the value is found, it is stored in the cache and returned.
@@ -94,49 +339,229 @@ func (g *Group) Get(key string) (ByteView, error) {
 // If the value is found locally, it is stored in the cache and returned.
 // All the fetch are done through the loader function to make sure that each key is fetched once at the same time.
 func (g *Group) load(key string) (value ByteView, err error) {
+	g.loads.Add(1)
+	defer g.loads.Add(-1)
+	g.Stats.Loads.Add(1)
 
-	result, err := g.loader.Do(key, func() (interface{}, error) {
+	value, err, _ = g.loader.Do(key, func() (ByteView, error) {
+		g.Stats.LoadsDeduped.Add(1)
 		if g.peers != nil {
 			if peer, ok := g.peers.PickPeer(key); ok {
-				if value, err = g.getFromPeer(peer, key); err == nil {
+				value, local, err := g.loadFromPeer(peer, key)
+				if local {
+					return g.countLocal(value, err)
+				}
+				if err == nil {
+					g.Stats.PeerLoads.Add(1)
 					return value, nil
 				}
+				// the owner says the key does not exist, don't ask the Getter again
+				if IsNotFound(err) {
+					g.populateNegative(key, err)
+					return ByteView{}, err
+				}
+				g.Stats.PeerErrors.Add(1)
 				log.Println("[GeeCache] failed to get from peer", err)
+				if l, ok := peer.(leaser); ok && g.leases != nil {
+					if value, done, err := g.loadLeased(l, key); done {
+						return value, err
+					}
+				}
+			} else if g.leases != nil {
+				// this node owns key, peers that failed to get it from here may be loading it
+				if value, done, err := g.loadLeased(g.leases, key); done {
+					return value, err
+				}
 			}
 		}
-		return g.getLocally(key)
+		return g.countLocal(g.getLocally(key))
 	})
-	if err == nil {
-		return result.(ByteView), nil
-	}
 	return
 }
 
+// Remove drops key from the group's cache on this node, including a cached
+// not-found error, so the next Get loads it again. Other peers keep their
+// copies until they expire or are evicted. A load of key in flight is
+// forgotten, so the next Get doesn't wait for it.
+func (g *Group) Remove(key string) {
+	g.loader.Forget(key)
+	g.negCache.remove(key)
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+}
+
+// countLocal counts a local load in the stats, returning its result for singleflight.
+func (g *Group) countLocal(value ByteView, err error) (ByteView, error) {
+	if err != nil {
+		g.Stats.LocalLoadErrs.Add(1)
+		return ByteView{}, err
+	}
+	g.Stats.LocalLoads.Add(1)
+	return value, nil
+}
+
+// refresh reloads key in the background unless it is already being refreshed.
+// On failure the cached value is left as is.
+func (g *Group) refresh(key string) {
+	if _, loaded := g.refreshing.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+	g.Stats.Refreshes.Add(1)
+	// counted right away so Drain can't miss a refresh about to start
+	g.loads.Add(1)
+	go func() {
+		defer g.loads.Add(-1)
+		defer g.refreshing.Delete(key)
+		if _, err := g.load(key); err != nil {
+			g.Stats.RefreshErrs.Add(1)
+			log.Println("[GeeCache] failed to refresh", key, err)
+		}
+	}()
+}
+
+// Drain waits until the group has no in-flight loads or ctx is done.
+func (g *Group) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for g.loads.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("group %s: %d loads still in flight: %w", g.name, g.loads.Load(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
 func (g *Group) getLocally(key string) (ByteView, error) {
-	bytes, err := g.getter.Get(key)
+	value, err := g.loadLocally(key)
+	return g.cacheLocal(key, value, err)
+}
+
+// loadLocally loads key from the Getter without caching it.
+func (g *Group) loadLocally(key string) (ByteView, error) {
+	g.originLoads.Add(1)
+	defer g.originLoads.Add(-1)
+	if b := g.bulkhead; b != nil {
+		if err := b.acquire(key); err != nil {
+			return ByteView{}, err
+		}
+		defer b.release()
+	}
+	var value ByteView
+	var err error
+	start := time.Now()
+	if vg, ok := g.getter.(viewGetter); ok {
+		value, err = vg.getView(key)
+	} else {
+		var bytes []byte
+		bytes, err = g.getter.Get(key)
+		value = ByteView{b: cloneBytes(bytes)}
+	}
+	g.loaded(key, LoadLocal, start, err)
 	if err != nil {
 		return ByteView{}, err
 	}
-	value := ByteView{b: cloneBytes(bytes)}
-	g.populateCache(key, value)
+	value.e = g.expireAt()
 	return value, nil
 }
 
-func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
+// cacheLocal caches the result of a local load of key, a not-found error included.
+func (g *Group) cacheLocal(key string, value ByteView, err error) (ByteView, error) {
+	if err != nil {
+		if IsNotFound(err) {
+			g.populateNegative(key, err)
+		}
+		return ByteView{}, err
+	}
+	return g.populateCache(key, value), nil
+}
+
+// getFromPeer gets key from peer without caching it. stale reports that the
+// owner served an expired value, which must not be cached.
+func (g *Group) getFromPeer(ctx context.Context, peer PeerGetter, key string) (value ByteView, stale bool, err error) {
 	req := &pb.Request{
 		Group: g.name,
 		Key:   key,
 	}
 	res := &pb.Response{}
-	err := peer.Get(req, res)
+	start := time.Now()
+	if cg, ok := peer.(contextGetter); ok {
+		err = cg.getContext(ctx, req, res)
+	} else {
+		err = peer.Get(req, res)
+	}
+	g.loaded(key, LoadPeer, start, err)
+	if err != nil {
+		return ByteView{}, false, err
+	}
+	value, err = g.received(ByteView{b: res.Value, e: g.expireAt()}, res.GetEncoding())
+	if err != nil {
+		return ByteView{}, false, err
+	}
+	if res.GetFlags()&uint32(pb.Flag_STALE) != 0 {
+		return value, true, nil
+	}
+	// don't keep the value past its expiry on the owner
+	if ttl := res.GetTtl(); ttl > 0 {
+		if e := time.Now().Add(time.Duration(ttl)); value.e.IsZero() || e.Before(value.e) {
+			value.e = e
+		}
+	}
+	return value, false, nil
+}
+
+// cachePeer caches a value of key got from its owner, unless it is stale.
+func (g *Group) cachePeer(key string, value ByteView, stale bool, err error) (ByteView, error) {
 	if err != nil {
 		return ByteView{}, err
 	}
-	value := ByteView{b: res.Value}
-	g.populateCache(key, value)
-	return value, nil
+	// the owner's value is stale, use it once without caching it
+	if stale {
+		return value, nil
+	}
+	return g.populateHot(key, value), nil
+}
+
+// populateCache stores value and returns the stored view. Values loaded
+// after the group was deleted are not stored.
+func (g *Group) populateCache(key string, value ByteView) ByteView {
+	return g.populate(&g.mainCache, key, value)
+}
+
+// populateHot stores value of a key owned by a peer in the hot cache, if
+// enabled, and returns the stored view.
+func (g *Group) populateHot(key string, value ByteView) ByteView {
+	if g.hotCache.cacheBytes <= 0 {
+		return g.populateCache(key, value)
+	}
+	return g.populate(&g.hotCache, key, value)
+}
+
+func (g *Group) populate(c *cache, key string, value ByteView) ByteView {
+	g.negCache.remove(key)
+	if g.refreshAhead > 0 {
+		g.hits.reset(key)
+	}
+	return c.add(key, value)
 }
 
-func (g *Group) populateCache(key string, value ByteView) {
-	g.mainCache.add(key, value)
+// populateNegative records that a loaded key does not exist, caching the
+// not-found error if negative caching is enabled. Such a key got past the
+// key filter, if any, so it counts as one of its false positives.
+func (g *Group) populateNegative(key string, err error) {
+	if g.filter.Load() != nil {
+		g.Stats.FilterFalsePositives.Add(1)
+	}
+	if g.negativeTTL > 0 {
+		g.negCache.add(key, err, time.Now().Add(g.negativeTTL))
+	}
+}
+
+// expireAt returns the expiry time for a value loaded now.
+func (g *Group) expireAt() time.Time {
+	if g.ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(g.ttl)
 }
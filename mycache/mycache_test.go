@@ -1,9 +1,18 @@
 package mycache
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	pb "mycache/mycachepb"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var db = map[string]string{
@@ -12,6 +21,29 @@ var db = map[string]string{
 	"key3": "value3",
 }
 
+// newTestGroup creates a group with a main cache of cacheBytes that is
+// deleted when the test ends, so that tests can run more than once.
+func newTestGroup(t testing.TB, name string, cacheBytes int64, getter Getter, opts ...Option) *Group {
+	t.Helper()
+	g, err := NewGroup(name, getter, append([]Option{WithCacheBytes(cacheBytes)}, opts...)...)
+	if err != nil {
+		t.Fatalf("creating group %s: %v", name, err)
+	}
+	t.Cleanup(func() { g.registry.Delete(name) })
+	return g
+}
+
+// newTestTypedGroup is newTestGroup for typed groups.
+func newTestTypedGroup[T any](t testing.TB, name string, getter TypedGetter[T], codec Codec[T]) *TypedGroup[T] {
+	t.Helper()
+	g, err := NewTypedGroup(name, getter, codec, WithCacheBytes(2<<10))
+	if err != nil {
+		t.Fatalf("creating group %s: %v", name, err)
+	}
+	t.Cleanup(func() { g.group.registry.Delete(name) })
+	return g
+}
+
 func TestGet(t *testing.T) {
 	loadCounts := make(map[string]int, len(db))
 	f := GetterFunc(func(key string) ([]byte, error) {
@@ -25,7 +57,7 @@ func TestGet(t *testing.T) {
 		}
 		return []byte{}, fmt.Errorf("%s is not exists", key)
 	})
-	gee := NewGroup("testGroup", 2<<10, f)
+	gee := newTestGroup(t, "testGroup", 2<<10, f)
 	for k, v := range db {
 		if view, err := gee.Get(k); err != nil || view.String() != v {
 			t.Fatalf("Failed to get value of %s", k)
@@ -39,3 +71,936 @@ func TestGet(t *testing.T) {
 		t.Fatalf("the value of unknown should be empty, but %s got", view)
 	}
 }
+
+func TestNewGroupOptions(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local"), nil
+	})
+	stats := make(chan string, 1)
+	g := newTestGroup(t, "optionsGroup", 2<<10, getter,
+		WithHotCacheBytes(1<<10),
+		WithTTL(time.Hour),
+		WithPeers(testPeer(func(in *pb.Request, out *pb.Response) error {
+			out.Value = []byte("remote")
+			return nil
+		})),
+		WithStatsHook(5*time.Millisecond, func(group string, s *Stats) {
+			select {
+			case stats <- group:
+			default:
+			}
+		}))
+	if _, err := NewGroup("optionsGroup", getter); !errors.Is(err, ErrGroupExists) {
+		t.Fatalf("creating a group twice should fail with ErrGroupExists, got %v", err)
+	}
+	if GetGroup("optionsGroup") != g {
+		t.Fatalf("a failed NewGroup should not replace the existing group")
+	}
+
+	// a duplicate group doesn't open the disk cache of the existing one
+	dir := t.TempDir()
+	disk := newTestGroup(t, "diskOptionsGroup", 2<<10, getter, WithDiskCache(dir, 0))
+	disk.mainCache.disk.Put("key", []byte("on disk"), time.Time{})
+	listed := false
+	_, err := NewGroup("diskOptionsGroup", getter, WithDiskCache(dir, 0),
+		WithKeyFilter(func(add func(string)) error { listed = true; return nil }, 10, 0.01, 0))
+	if !errors.Is(err, ErrGroupExists) || listed {
+		t.Fatalf("a duplicate group should fail before its options with side effects run, got %v", err)
+	}
+	if v, _, ok := disk.mainCache.disk.Get("key"); !ok || string(v) != "on disk" {
+		t.Fatalf("the disk tier of the existing group should survive a duplicate NewGroup")
+	}
+
+	if v, err := g.Get("key"); err != nil || v.String() != "remote" {
+		t.Fatalf("Get = %q, %v; want the peer's value", v.String(), err)
+	}
+	if _, ok := g.mainCache.get("key"); ok {
+		t.Errorf("values of peers should not go to the main cache when the hot cache is enabled")
+	}
+	if v, ok := g.hotCache.get("key"); !ok || v.Expire().IsZero() {
+		t.Errorf("values of peers should go to the hot cache with the group's TTL")
+	}
+	select {
+	case name := <-stats:
+		if name != "optionsGroup" {
+			t.Errorf("stats hook called for %q", name)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("stats hook not called")
+	}
+
+	for name, opt := range map[string]Option{
+		"cache size": WithCacheBytes(-1),
+		"key filter": WithKeyFilter(func(func(string)) error { return errors.New("no keys") }, 10, 0.01, 0),
+	} {
+		if _, err := NewGroup("badOptionsGroup", getter, opt); err == nil {
+			t.Errorf("%s: a bad option should fail NewGroup", name)
+		}
+		if GetGroup("badOptionsGroup") != nil {
+			t.Errorf("%s: a group failing NewGroup should not be registered", name)
+		}
+	}
+	if _, err := NewGroup("badOptionsGroup", getter); err != nil {
+		t.Fatalf("the name of a group failing NewGroup should be free again, got %v", err)
+	}
+	DeleteGroup("badOptionsGroup")
+}
+
+func TestDeleteGroup(t *testing.T) {
+	release := make(chan struct{})
+	g := newTestGroup(t, "deletedGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "slow" {
+			<-release
+		}
+		return []byte(key), nil
+	}))
+	g.Get("key")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.Get("slow")
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	if !DeleteGroup("deletedGroup") || DeleteGroup("deletedGroup") {
+		t.Fatalf("DeleteGroup should report whether the group existed")
+	}
+	if GetGroup("deletedGroup") != nil {
+		t.Fatalf("deleted group should not be registered")
+	}
+	if _, ok := g.mainCache.get("key"); ok {
+		t.Fatalf("deleted group should drop its cache")
+	}
+	close(release)
+	<-done
+	if _, ok := g.mainCache.get("slow"); ok {
+		t.Fatalf("loads finishing after the group is deleted should not be cached")
+	}
+	// even those that got past the group's checks before it was deleted
+	g.hotCache.add("late", ByteView{s: "late"})
+	g.negCache.add("late", ErrNotFound, time.Now().Add(time.Minute))
+	if _, ok := g.hotCache.get("late"); ok {
+		t.Fatalf("closed cache should not store values")
+	}
+	if _, ok := g.negCache.get("late"); ok {
+		t.Fatalf("closed negative cache should not store errors")
+	}
+
+	// the name is free again
+	newTestGroup(t, "deletedGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, nil
+	}))
+}
+
+func TestTTL(t *testing.T) {
+	loads := 0
+	g := newTestGroup(t, "ttlGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(key), nil
+	}))
+	g.SetTTL(20 * time.Millisecond)
+
+	if _, err := g.Get("key"); err != nil || loads != 1 {
+		t.Fatalf("first get should load, loads=%d err=%v", loads, err)
+	}
+	if _, err := g.Get("key"); err != nil || loads != 1 {
+		t.Fatalf("second get should hit, loads=%d err=%v", loads, err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, err := g.Get("key"); err != nil || loads != 2 {
+		t.Fatalf("get after ttl should reload, loads=%d err=%v", loads, err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	release := make(chan struct{})
+	g := newTestGroup(t, "drainGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		<-release
+		return []byte(key), nil
+	}))
+	go g.Get("slow")
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := g.Drain(ctx); err == nil {
+		t.Fatalf("drain should time out while a load is in flight")
+	}
+
+	close(release)
+	if err := g.Drain(context.Background()); err != nil {
+		t.Fatalf("drain should return once loads finish, got %v", err)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value of " + key), nil
+	})
+	src := newTestGroup(t, "snapshotGroup", 2<<10, getter)
+	src.SetTTL(time.Hour)
+	for _, k := range []string{"a", "b", "c"} {
+		src.Get(k)
+	}
+	src.Get("a") // a is now the most recently used
+
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatalf("snapshot failed: %v", err)
+	}
+
+	want := make(map[string]ByteView)
+	for _, e := range src.mainCache.hottest(10) {
+		want[e.key] = e.value
+	}
+	// restore into a new group of the same name
+	DeleteGroup("snapshotGroup")
+
+	loads := 0
+	dst := newTestGroup(t, "snapshotGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return nil, fmt.Errorf("%s not exist", key)
+	}))
+	if err := dst.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	var keys []string
+	for _, e := range dst.mainCache.hottest(10) {
+		keys = append(keys, e.key)
+		if w := want[e.key]; e.value.String() != w.String() || !e.value.Expire().Equal(w.Expire()) {
+			t.Errorf("restored %s=%s expiring %v, want %s expiring %v", e.key, e.value, e.value.Expire(), w, w.Expire())
+		}
+	}
+	if fmt.Sprint(keys) != "[a c b]" {
+		t.Errorf("restore should keep the LRU order, got %v", keys)
+	}
+	if loads != 0 {
+		t.Errorf("restored keys should not be loaded, got %d loads", loads)
+	}
+}
+
+func TestRestoreCorrupt(t *testing.T) {
+	g := newTestGroup(t, "corruptGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	g.Get("key1")
+	g.Get("key2")
+	var buf bytes.Buffer
+	if err := g.Snapshot(&buf); err != nil {
+		t.Fatalf("snapshot failed: %v", err)
+	}
+	good := buf.Bytes()
+	DeleteGroup("corruptGroup")
+
+	flipped := bytes.Clone(good)
+	flipped[len(flipped)/2] ^= 0xff
+	testCases := map[string][]byte{
+		"empty":     {},
+		"bad magic": append([]byte("XXXX"), good[4:]...),
+		"truncated": good[:len(good)-3],
+		"bit flip":  flipped,
+		"huge len":  append(bytes.Clone(good[:len(good)-4]), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f),
+	}
+	for name, data := range testCases {
+		fresh := newTestGroup(t, "corruptGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+		if err := fresh.Restore(bytes.NewReader(data)); !errors.Is(err, ErrCorruptSnapshot) {
+			t.Errorf("%s: restore should fail with ErrCorruptSnapshot, got %v", name, err)
+		}
+		if _, ok := fresh.mainCache.get("key1"); ok {
+			t.Errorf("%s: corrupt snapshot should not populate the cache", name)
+		}
+		DeleteGroup("corruptGroup")
+	}
+
+	other := newTestGroup(t, "otherGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	if err := other.Restore(bytes.NewReader(good)); err == nil {
+		t.Errorf("restoring a snapshot of another group should fail")
+	}
+}
+
+type missingKeyError struct{ key string }
+
+func (e *missingKeyError) Error() string  { return e.key + " is missing" }
+func (e *missingKeyError) NotFound() bool { return true }
+
+func TestNegativeCache(t *testing.T) {
+	loads := 0
+	errMissing := fmt.Errorf("%w: unknown", ErrNotFound)
+	g := newTestGroup(t, "negativeGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		switch key {
+		case "unknown":
+			return nil, errMissing
+		case "custom":
+			return nil, &missingKeyError{key}
+		}
+		return nil, fmt.Errorf("db is down")
+	}))
+	g.SetNegativeTTL(20 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		if _, err := g.Get("unknown"); err != errMissing {
+			t.Fatalf("not-found error should be returned unchanged, got %v", err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("not-found error should be cached, got %d loads", loads)
+	}
+
+	g.Get("custom")
+	if _, err := g.Get("custom"); !IsNotFound(err) || loads != 2 {
+		t.Fatalf("errors implementing NotFound should be cached, got %d loads, err %v", loads, err)
+	}
+
+	g.Get("broken")
+	g.Get("broken")
+	if loads != 4 {
+		t.Fatalf("other errors should not be cached, got %d loads", loads)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	g.Get("unknown")
+	if loads != 5 {
+		t.Fatalf("not-found error should expire, got %d loads", loads)
+	}
+}
+
+func TestKeyFilter(t *testing.T) {
+	loads := 0
+	g := newTestGroup(t, "filterGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}))
+	keys := []string{"key1", "key2"}
+	src := KeySource(func(add func(string)) error {
+		for _, k := range keys {
+			add(k)
+		}
+		return nil
+	})
+	if err := g.EnableKeyFilter(src, 100, 0.01, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := g.Get("key1"); err != nil || v.String() != "value1" {
+		t.Fatalf("key1 should pass the filter, got %v", err)
+	}
+	if _, err := g.Get("key3"); !IsNotFound(err) || loads != 1 {
+		t.Fatalf("key3 should be rejected before loading, loads=%d err=%v", loads, err)
+	}
+	if g.Stats.FilterRejects.Get() != 1 {
+		t.Fatalf("rejects should be counted, got %d", g.Stats.FilterRejects.Get())
+	}
+
+	// rebuilding swaps in the new key set
+	keys = append(keys, "key3")
+	if err := g.RebuildKeyFilter(src, 100, 0.01); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := g.Get("key3"); err != nil || v.String() != "value3" {
+		t.Fatalf("key3 should pass the rebuilt filter, got %v", err)
+	}
+	if stats, ok := g.FilterStats(); !ok || stats.Keys != 3 || stats.FalsePositiveRate <= 0 || stats.FalsePositiveRate > 0.01 {
+		t.Fatalf("unexpected filter stats %+v", stats)
+	}
+
+	// a failed rebuild keeps the old filter
+	if err := g.RebuildKeyFilter(func(func(string)) error { return fmt.Errorf("db is down") }, 100, 0.01); err == nil {
+		t.Fatalf("rebuild should report the key source error")
+	}
+	if stats, _ := g.FilterStats(); stats.Keys != 3 {
+		t.Fatalf("failed rebuild should keep the old filter, got %+v", stats)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var mu sync.Mutex
+	loads, fail := 0, false
+	g := newTestGroup(t, "staleGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			return nil, fmt.Errorf("db is down")
+		}
+		loads++
+		return []byte(strconv.Itoa(loads)), nil
+	}))
+	g.SetTTL(20 * time.Millisecond)
+	g.SetStaleWhileRevalidate(100 * time.Millisecond)
+
+	g.Get("key")
+	time.Sleep(30 * time.Millisecond)
+	if v, err := g.Get("key"); err != nil || v.String() != "1" {
+		t.Fatalf("expired value should be served stale, got %v %v", v, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if v, err := g.Get("key"); err != nil || v.String() != "2" {
+		t.Fatalf("stale value should have been refreshed in the background, got %v %v", v, err)
+	}
+
+	// failed refreshes keep serving the stale value until maxStale
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	time.Sleep(30 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if v, err := g.Get("key"); err != nil || v.String() != "2" {
+			t.Fatalf("stale value should survive a failed refresh, got %v %v", v, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if g.Stats.RefreshErrs.Get() == 0 {
+		t.Fatalf("failed refreshes should be counted")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := g.Get("key"); err == nil {
+		t.Fatalf("value past maxStale should not be served")
+	}
+}
+
+func TestRefreshAhead(t *testing.T) {
+	var loads atomic.Int64
+	g := newTestGroup(t, "refreshAheadGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(strconv.FormatInt(loads.Add(1), 10)), nil
+	}))
+	g.SetTTL(50 * time.Millisecond)
+	g.SetRefreshAhead(40 * time.Millisecond)
+
+	g.Get("key")
+	g.Get("key") // not within the window yet
+	time.Sleep(20 * time.Millisecond)
+	if v, err := g.Get("key"); err != nil || v.String() != "1" {
+		t.Fatalf("value should still be served from the cache, got %v %v", v, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if loads.Load() != 2 {
+		t.Fatalf("value read near expiry should be refreshed ahead, got %d loads", loads.Load())
+	}
+	time.Sleep(30 * time.Millisecond)
+	if v, err := g.Get("key"); err != nil || v.String() != "2" {
+		t.Fatalf("refreshed value should outlive the first ttl, got %v %v", v, err)
+	}
+
+	// a key loaded and read once near expiry is left to expire
+	before := loads.Load()
+	g.Get("cold")
+	time.Sleep(20 * time.Millisecond)
+	g.Get("cold")
+	time.Sleep(10 * time.Millisecond)
+	if loads.Load() != before+1 {
+		t.Fatalf("key read once should not be refreshed ahead, got %d loads", loads.Load()-before)
+	}
+}
+
+type typedValue struct {
+	Name  string
+	Count int
+}
+
+func TestTypedGroup(t *testing.T) {
+	codecs := map[string]Codec[typedValue]{
+		"json":    JSONCodec[typedValue]{},
+		"gob":     GobCodec[typedValue]{},
+		"msgpack": MsgpackCodec[typedValue]{},
+	}
+	for name, codec := range codecs {
+		var loads atomic.Int64
+		g := newTestTypedGroup(t, "typed-"+name, TypedGetterFunc[typedValue](func(key string) (typedValue, error) {
+			loads.Add(1)
+			if key == "missing" {
+				return typedValue{}, fmt.Errorf("%s not exist: %w", key, ErrNotFound)
+			}
+			return typedValue{Name: key, Count: len(key)}, nil
+		}), codec)
+
+		for i := 0; i < 2; i++ {
+			v, err := g.Get("Tom")
+			if err != nil || v != (typedValue{"Tom", 3}) {
+				t.Fatalf("%s: got %+v %v", name, v, err)
+			}
+		}
+		if loads.Load() != 1 || g.Stats.Decodes.Get() != 1 || g.Stats.DecodedHits.Get() != 1 {
+			t.Fatalf("%s: decoded value should be cached, loads %d decodes %d", name, loads.Load(), g.Stats.Decodes.Get())
+		}
+		if _, err := g.Get("missing"); !IsNotFound(err) {
+			t.Fatalf("%s: load errors should pass through, got %v", name, err)
+		}
+	}
+
+	pg := newTestTypedGroup(t, "typed-proto", TypedGetterFunc[*pb.Entry](func(key string) (*pb.Entry, error) {
+		return &pb.Entry{Key: []byte(key), Value: []byte("v")}, nil
+	}), ProtoCodec[*pb.Entry]{})
+	if e, err := pg.Get("Tom"); err != nil || string(e.GetKey()) != "Tom" || string(e.GetValue()) != "v" {
+		t.Fatalf("proto: got %v %v", e, err)
+	}
+
+	g := newTestTypedGroup(t, "typed-bad", TypedGetterFunc[chan int](func(key string) (chan int, error) {
+		return make(chan int), nil
+	}), JSONCodec[chan int]{})
+	var codecErr *CodecError
+	if _, err := g.Get("key"); !errors.As(err, &codecErr) || codecErr.Op != "marshal" {
+		t.Fatalf("unencodable value should be a CodecError, got %v", err)
+	}
+	if g.Stats.CodecErrs.Get() != 1 {
+		t.Fatalf("codec errors should be counted")
+	}
+
+	// deleting the group drops the decoded values too
+	DeleteGroup("typed-proto")
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+	if pg.decoded != nil {
+		t.Fatalf("deleted typed group should drop its decoded values")
+	}
+}
+
+func TestCompression(t *testing.T) {
+	values := map[string]string{
+		"small": "tiny",
+		"large": strings.Repeat("compressible ", 100),
+	}
+	g := newTestGroup(t, "compressionGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(values[key]), nil
+	}))
+	g.SetCompression(Gzip, 64)
+
+	for i := 0; i < 2; i++ {
+		for key, want := range values {
+			if v, err := g.Get(key); err != nil || v.String() != want {
+				t.Fatalf("Get(%s) = %q, %v", key, v.String(), err)
+			}
+		}
+	}
+	if v, _ := g.mainCache.get("large"); v.z == nil || v.Len() >= len(values["large"]) {
+		t.Fatalf("large value should be stored compressed, got %d bytes", v.Len())
+	}
+	if v, _ := g.mainCache.get("small"); v.z != nil {
+		t.Fatalf("value below the threshold should be stored raw")
+	}
+	if r := g.Stats.CompressionRatio(); r <= 1 {
+		t.Fatalf("compression ratio should be above 1, got %v", r)
+	}
+
+	var buf bytes.Buffer
+	if err := g.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), values["large"]) {
+		t.Fatalf("snapshot should hold uncompressed values")
+	}
+
+	// evicted values that fail to decompress are counted, not reported
+	broken := newTestGroup(t, "brokenCompressionGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(values[key]), nil
+	}))
+	broken.SetCompression(brokenCompressor{}, 0)
+	broken.SetHooks(Hooks{OnEvict: func(key string, value ByteView, reason EvictReason) {
+		t.Errorf("value of %s failing to decompress should not be reported", key)
+	}})
+	broken.Get("large")
+	broken.Remove("large")
+	if n := broken.Stats.DecompressErrs.Get(); n != 1 {
+		t.Fatalf("decompress errors should be counted, got %d", n)
+	}
+}
+
+// brokenCompressor compresses values it fails to decompress.
+type brokenCompressor struct{}
+
+func (brokenCompressor) Name() string { return "broken" }
+
+func (brokenCompressor) Compress(b []byte) ([]byte, error) { return b[:1], nil }
+
+func (brokenCompressor) Decompress(b []byte) ([]byte, error) {
+	return nil, errors.New("corrupt value")
+}
+
+// testPeer is a PeerPicker owning every key, answering with get.
+type testPeer func(in *pb.Request, out *pb.Response) error
+
+func (p testPeer) PickPeer(key string) (PeerGetter, bool) {
+	return p, true
+}
+
+func (p testPeer) Get(in *pb.Request, out *pb.Response) error {
+	return p(in, out)
+}
+
+func TestHedging(t *testing.T) {
+	var slow atomic.Bool
+	release := make(chan struct{})
+	slowDone := make(chan struct{}, 1)
+	g := newTestGroup(t, "hedgingGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local"), nil
+	}))
+	g.RegisterPeers(testPeer(func(in *pb.Request, out *pb.Response) error {
+		if slow.Load() {
+			// the slow peer answers once the hedged load is over
+			<-release
+			defer func() { slowDone <- struct{}{} }()
+		} else {
+			time.Sleep(time.Millisecond)
+		}
+		out.Value = []byte("peer")
+		return nil
+	}))
+	g.SetHedging(HedgeOptions{MinDelay: 20 * time.Millisecond, MaxDelay: 100 * time.Millisecond})
+
+	for i := 0; i < 2*minLatencySamples; i++ {
+		key := "fast" + strconv.Itoa(i)
+		if v, err := g.Get(key); err != nil || v.String() != "peer" {
+			t.Fatalf("Get(%s) = %q, %v", key, v.String(), err)
+		}
+	}
+	if g.Stats.Hedges.Get() != 0 {
+		t.Fatalf("fast peer loads should not be hedged")
+	}
+	if d := g.hedge.hedgeDelay(); d >= 100*time.Millisecond {
+		t.Fatalf("hedging delay should follow the peer latencies, got %v", d)
+	}
+
+	slow.Store(true)
+	start := time.Now()
+	if v, err := g.Get("slow"); err != nil || v.String() != "local" {
+		t.Fatalf("hedged load should be won by the local load, got %q, %v", v.String(), err)
+	}
+	if d := time.Since(start); d > 200*time.Millisecond {
+		t.Fatalf("hedged load should not wait for the slow peer, took %v", d)
+	}
+	close(release)
+	if g.Stats.Hedges.Get() != 1 || g.Stats.HedgeWins.Get() != 1 {
+		t.Fatalf("hedge should be counted, got %d hedges %d wins", g.Stats.Hedges.Get(), g.Stats.HedgeWins.Get())
+	}
+	// a losing peer load that can't be cancelled is not cached
+	<-slowDone
+	if v, ok := g.mainCache.get("slow"); !ok || v.String() != "local" {
+		t.Fatalf("losing peer load should not be cached, got %q", v.String())
+	}
+
+	// a losing peer load that can be cancelled is cancelled
+	peer := cancelPeer(make(chan error, 1))
+	cancelled := newTestGroup(t, "hedgingCancelGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local"), nil
+	}), WithPeers(peer), WithHedging(HedgeOptions{MaxDelay: 10 * time.Millisecond}))
+	if v, err := cancelled.Get("key"); err != nil || v.String() != "local" {
+		t.Fatalf("hedged load should be won by the local load, got %q, %v", v.String(), err)
+	}
+	select {
+	case err := <-peer:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("losing peer load should be cancelled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("losing peer load should be cancelled")
+	}
+}
+
+// cancelPeer is a PeerPicker owning every key, whose requests hang until
+// they are cancelled and then send their error.
+type cancelPeer chan error
+
+func (p cancelPeer) PickPeer(key string) (PeerGetter, bool) {
+	return p, true
+}
+
+func (p cancelPeer) Get(in *pb.Request, out *pb.Response) error {
+	return p.getContext(context.Background(), in, out)
+}
+
+func (p cancelPeer) getContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	<-ctx.Done()
+	p <- ctx.Err()
+	return ctx.Err()
+}
+
+func TestRetries(t *testing.T) {
+	var calls atomic.Int64
+	g := newTestGroup(t, "retriesGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local"), nil
+	}))
+	g.RegisterPeers(testPeer(func(in *pb.Request, out *pb.Response) error {
+		n := calls.Add(1)
+		if in.GetKey() == "broken" {
+			return &PeerError{Code: pb.Code_INTERNAL, Message: "broken"}
+		}
+		if n < 3 {
+			return &PeerError{Code: pb.Code_UNAVAILABLE, Message: "busy", Retryable: true}
+		}
+		out.Value = []byte("peer")
+		return nil
+	}))
+	g.SetRetries(RetryOptions{MaxRetries: 2, Backoff: time.Millisecond})
+
+	if v, err := g.Get("key"); err != nil || v.String() != "peer" {
+		t.Fatalf("retried load should succeed, got %q, %v", v.String(), err)
+	}
+	if calls.Load() != 3 || g.Stats.PeerRetries.Get() != 2 {
+		t.Fatalf("retryable errors should be retried, got %d calls", calls.Load())
+	}
+
+	calls.Store(0)
+	if v, err := g.Get("broken"); err != nil || v.String() != "local" {
+		t.Fatalf("failed peer load should fall back to the getter, got %q, %v", v.String(), err)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("errors that aren't retryable should not be retried, got %d calls", calls.Load())
+	}
+}
+
+func TestLoadLimit(t *testing.T) {
+	gate := make(chan struct{})
+	started := make(chan struct{})
+	var mu sync.Mutex
+	var order []string
+	g := newTestGroup(t, "loadLimitGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "first" {
+			close(started)
+			<-gate
+		}
+		mu.Lock()
+		order = append(order, key)
+		mu.Unlock()
+		return []byte(key), nil
+	}))
+	g.SetLoadLimit(LoadLimitOptions{
+		MaxConcurrent: 1,
+		MaxQueue:      3,
+		Priority: func(key string) int {
+			if strings.HasPrefix(key, "high") {
+				return 1
+			}
+			return 0
+		},
+	})
+
+	var wg sync.WaitGroup
+	get := func(key string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := g.Get(key); err != nil {
+				t.Errorf("Get(%s) failed: %v", key, err)
+			}
+		}()
+	}
+	get("first")
+	<-started
+	for i, key := range []string{"low1", "high1", "low2"} {
+		get(key)
+		for g.Stats.LoadQueueDepth.Get() != int64(i+1) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if _, err := g.Get("overflow"); !errors.Is(err, ErrOverloaded) || g.Stats.LoadQueueRejects.Get() != 1 {
+		t.Fatalf("load past a full queue should fail, got %v", err)
+	}
+	close(gate)
+	wg.Wait()
+
+	if want := []string{"first", "high1", "low1", "low2"}; fmt.Sprint(order) != fmt.Sprint(want) {
+		t.Fatalf("loads should run by priority then arrival, got %v, want %v", order, want)
+	}
+	if g.Stats.LoadQueueWaits.Get() != 3 || g.Stats.LoadQueueDepth.Get() != 0 || g.Stats.MeanLoadQueueWait() <= 0 {
+		t.Fatalf("queued loads should be counted, got %d waits", g.Stats.LoadQueueWaits.Get())
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	started = make(chan struct{})
+	slow := newTestGroup(t, "loadLimitTimeoutGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "slow" {
+			close(started)
+			<-release
+		}
+		return []byte(key), nil
+	}))
+	slow.SetLoadLimit(LoadLimitOptions{MaxConcurrent: 1, QueueTimeout: 20 * time.Millisecond})
+	go slow.Get("slow")
+	<-started
+	if _, err := slow.Get("key"); !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("load queued past the timeout should fail, got %v", err)
+	}
+	if e := toPB(fmt.Errorf("load: %w", ErrOverloaded)); e.GetCode() != pb.Code_UNAVAILABLE || e.GetRetryable() {
+		t.Fatalf("overloaded group should answer peers unavailable, got %v", e)
+	}
+}
+
+// leasingPeer owns every key, failing to get them but granting leases to load them.
+type leasingPeer struct{ *leaseTable }
+
+func (p leasingPeer) PickPeer(key string) (PeerGetter, bool) {
+	return p, true
+}
+
+func (p leasingPeer) Get(in *pb.Request, out *pb.Response) error {
+	return &PeerError{Code: pb.Code_UNAVAILABLE, Message: "overloaded"}
+}
+
+func TestLoadLease(t *testing.T) {
+	var loads atomic.Int64
+	waiting := make(chan string, 2)
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		// the holder loads once the two other nodes wait for it
+		for i := 0; i < 2; i++ {
+			select {
+			case <-waiting:
+			case <-time.After(time.Second):
+				t.Errorf("%s: other nodes should wait for the lease holder", key)
+			}
+		}
+		if key == "missing" {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return []byte("value"), nil
+	})
+	owner := leasingPeer{&leaseTable{leases: make(map[string]*lease), onWait: func(key string) {
+		waiting <- key
+	}}}
+	var groups []*Group
+	for _, name := range []string{"leaseGroupA", "leaseGroupB", "leaseGroupC"} {
+		g := newTestGroup(t, name, 2<<10, getter)
+		g.RegisterPeers(owner)
+		g.SetLoadLease(LeaseOptions{TTL: time.Second})
+		groups = append(groups, g)
+	}
+
+	var wg sync.WaitGroup
+	for _, g := range groups {
+		wg.Add(1)
+		go func(g *Group) {
+			defer wg.Done()
+			if v, err := g.Get("key"); err != nil || v.String() != "value" {
+				t.Errorf("%s: Get = %q, %v", g.name, v.String(), err)
+			}
+		}(g)
+	}
+	wg.Wait()
+	if loads.Load() != 1 {
+		t.Fatalf("nodes failing to get a key from its owner should load it once, got %d loads", loads.Load())
+	}
+	var leases, waits int64
+	for _, g := range groups {
+		leases += g.Stats.Leases.Get()
+		waits += g.Stats.LeaseWaits.Get()
+	}
+	if leases != 1 || waits != 2 {
+		t.Fatalf("one node should load under a lease and the others wait, got %d leases %d waits", leases, waits)
+	}
+
+	// the holder's not-found error is handed to the others
+	loads.Store(0)
+	for _, g := range groups {
+		wg.Add(1)
+		go func(g *Group) {
+			defer wg.Done()
+			if _, err := g.Get("missing"); !IsNotFound(err) {
+				t.Errorf("%s: missing key should not be found, got %v", g.name, err)
+			}
+		}(g)
+	}
+	wg.Wait()
+	if loads.Load() != 1 {
+		t.Fatalf("nodes waiting for a key found missing should not load it, got %d loads", loads.Load())
+	}
+
+	// a holder that fails or dies lets the others take over
+	table := &leaseTable{leases: make(map[string]*lease)}
+	start := time.Now()
+	first, _ := table.acquire("", "k", 20*time.Millisecond, time.Second)
+	if first.id == 0 {
+		t.Fatalf("first caller should get the lease")
+	}
+	second, _ := table.acquire("", "k", time.Second, time.Second)
+	if second.id == 0 || second.id == first.id || time.Since(start) < 20*time.Millisecond {
+		t.Fatalf("expired lease should be taken over, got %+v", second)
+	}
+	table.release("", "k", first.id, ByteView{}, nil)
+	if third, _ := table.acquire("", "k", time.Second, 20*time.Millisecond); third.id != 0 || third.loaded {
+		t.Fatalf("release of an expired lease should be ignored and waits time out, got %+v", third)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	record := func(format string, args ...interface{}) {
+		mu.Lock()
+		events = append(events, fmt.Sprintf(format, args...))
+		mu.Unlock()
+	}
+	var g *Group
+	g = newTestGroup(t, "hooksGroup", 2, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("v"), nil
+	}), WithHooks(Hooks{
+		OnEvict: func(key string, value ByteView, reason EvictReason) {
+			record("evict %s=%s %v", key, value, reason)
+			g.cached(key) // the cache is not locked while hooks run
+		},
+		OnLoad: func(key string, source LoadSource, latency time.Duration, err error) {
+			record("load %s %v %v", key, source, err)
+		},
+		OnHit: func(key string) {
+			record("hit %s", key)
+		},
+	}))
+
+	g.Get("a")
+	g.Get("a")
+	g.Get("b") // evicts a, the cache holds one entry
+	g.Remove("b")
+	g.populateCache("c", ByteView{s: "1"})
+	g.populateCache("c", ByteView{s: "2"})
+	g.populateCache("c", ByteView{s: "3", e: time.Now().Add(-time.Second)})
+	g.cached("c")
+	g.populateCache("d", ByteView{s: "4"})
+	g.registry.Delete("hooksGroup")
+
+	want := []string{
+		"load a local <nil>",
+		"hit a",
+		"load b local <nil>",
+		"evict a=v capacity",
+		"evict b=v removed",
+		"evict c=1 invalidated",
+		"evict c=2 invalidated",
+		"evict c=3 expired",
+		"evict d=4 invalidated",
+	}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Fatalf("hooks got\n%v\nwant\n%v", strings.Join(events, "\n"), strings.Join(want, "\n"))
+	}
+
+	var source LoadSource = -1
+	remote := newTestGroup(t, "peerHooksGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local"), nil
+	}), WithPeers(testPeer(func(in *pb.Request, out *pb.Response) error {
+		out.Value = []byte("remote")
+		return nil
+	})), WithHooks(Hooks{OnLoad: func(key string, s LoadSource, latency time.Duration, err error) {
+		source = s
+	}}))
+	remote.Get("key")
+	if source != LoadPeer {
+		t.Fatalf("values of peers should be reported as loaded from a peer, got %v", source)
+	}
+}
+
+func TestRemoveDiskTier(t *testing.T) {
+	g := newTestGroup(t, "diskRemoveGroup", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("v"), nil
+	}), WithDiskCache(t.TempDir(), 1<<20))
+	g.Get("a")
+	g.Remove("a")
+	g.Get("b")
+	if _, _, ok := g.mainCache.disk.Get("a"); ok {
+		t.Fatalf("removed key should not be demoted to disk")
+	}
+}
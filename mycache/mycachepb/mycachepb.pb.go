@@ -21,6 +21,119 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type Flag int32
+
+const (
+	Flag_NO_FLAGS Flag = 0
+	Flag_STALE    Flag = 1
+)
+
+// Enum value maps for Flag.
+var (
+	Flag_name = map[int32]string{
+		0: "NO_FLAGS",
+		1: "STALE",
+	}
+	Flag_value = map[string]int32{
+		"NO_FLAGS": 0,
+		"STALE":    1,
+	}
+)
+
+func (x Flag) Enum() *Flag {
+	p := new(Flag)
+	*p = x
+	return p
+}
+
+func (x Flag) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Flag) Descriptor() protoreflect.EnumDescriptor {
+	return file_mycache_mycachepb_proto_enumTypes[0].Descriptor()
+}
+
+func (Flag) Type() protoreflect.EnumType {
+	return &file_mycache_mycachepb_proto_enumTypes[0]
+}
+
+func (x Flag) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Flag.Descriptor instead.
+func (Flag) EnumDescriptor() ([]byte, []int) {
+	return file_mycache_mycachepb_proto_rawDescGZIP(), []int{0}
+}
+
+type Code int32
+
+const (
+	Code_UNKNOWN         Code = 0
+	Code_NOT_FOUND       Code = 1
+	Code_NO_SUCH_GROUP   Code = 2
+	Code_BAD_REQUEST     Code = 3
+	Code_UNAUTHENTICATED Code = 4
+	Code_FORBIDDEN       Code = 5
+	Code_UNAVAILABLE     Code = 6
+	Code_TIMEOUT         Code = 7
+	Code_INTERNAL        Code = 8
+)
+
+// Enum value maps for Code.
+var (
+	Code_name = map[int32]string{
+		0: "UNKNOWN",
+		1: "NOT_FOUND",
+		2: "NO_SUCH_GROUP",
+		3: "BAD_REQUEST",
+		4: "UNAUTHENTICATED",
+		5: "FORBIDDEN",
+		6: "UNAVAILABLE",
+		7: "TIMEOUT",
+		8: "INTERNAL",
+	}
+	Code_value = map[string]int32{
+		"UNKNOWN":         0,
+		"NOT_FOUND":       1,
+		"NO_SUCH_GROUP":   2,
+		"BAD_REQUEST":     3,
+		"UNAUTHENTICATED": 4,
+		"FORBIDDEN":       5,
+		"UNAVAILABLE":     6,
+		"TIMEOUT":         7,
+		"INTERNAL":        8,
+	}
+)
+
+func (x Code) Enum() *Code {
+	p := new(Code)
+	*p = x
+	return p
+}
+
+func (x Code) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Code) Descriptor() protoreflect.EnumDescriptor {
+	return file_mycache_mycachepb_proto_enumTypes[1].Descriptor()
+}
+
+func (Code) Type() protoreflect.EnumType {
+	return &file_mycache_mycachepb_proto_enumTypes[1]
+}
+
+func (x Code) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Code.Descriptor instead.
+func (Code) EnumDescriptor() ([]byte, []int) {
+	return file_mycache_mycachepb_proto_rawDescGZIP(), []int{1}
+}
+
 type Request struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
@@ -76,6 +189,10 @@ func (x *Request) GetKey() string {
 type Response struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Value         []byte                 `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
+	Encoding      string                 `protobuf:"bytes,2,opt,name=encoding,proto3" json:"encoding,omitempty"`
+	Ttl           int64                  `protobuf:"varint,3,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	Version       uint32                 `protobuf:"varint,4,opt,name=version,proto3" json:"version,omitempty"`
+	Flags         uint32                 `protobuf:"varint,5,opt,name=flags,proto3" json:"flags,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -117,6 +234,502 @@ func (x *Response) GetValue() []byte {
 	return nil
 }
 
+func (x *Response) GetEncoding() string {
+	if x != nil {
+		return x.Encoding
+	}
+	return ""
+}
+
+func (x *Response) GetTtl() int64 {
+	if x != nil {
+		return x.Ttl
+	}
+	return 0
+}
+
+func (x *Response) GetVersion() uint32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *Response) GetFlags() uint32 {
+	if x != nil {
+		return x.Flags
+	}
+	return 0
+}
+
+type GetRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Group          string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Key            []byte                 `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	AcceptEncoding []string               `protobuf:"bytes,3,rep,name=accept_encoding,json=acceptEncoding,proto3" json:"accept_encoding,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *GetRequest) Reset() {
+	*x = GetRequest{}
+	mi := &file_mycache_mycachepb_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRequest) ProtoMessage() {}
+
+func (x *GetRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mycache_mycachepb_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRequest.ProtoReflect.Descriptor instead.
+func (*GetRequest) Descriptor() ([]byte, []int) {
+	return file_mycache_mycachepb_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *GetRequest) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *GetRequest) GetAcceptEncoding() []string {
+	if x != nil {
+		return x.AcceptEncoding
+	}
+	return nil
+}
+
+type Entry struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           []byte                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value         []byte                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Expire        int64                  `protobuf:"varint,3,opt,name=expire,proto3" json:"expire,omitempty"`
+	Encoding      string                 `protobuf:"bytes,4,opt,name=encoding,proto3" json:"encoding,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Entry) Reset() {
+	*x = Entry{}
+	mi := &file_mycache_mycachepb_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Entry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Entry) ProtoMessage() {}
+
+func (x *Entry) ProtoReflect() protoreflect.Message {
+	mi := &file_mycache_mycachepb_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Entry.ProtoReflect.Descriptor instead.
+func (*Entry) Descriptor() ([]byte, []int) {
+	return file_mycache_mycachepb_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Entry) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *Entry) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *Entry) GetExpire() int64 {
+	if x != nil {
+		return x.Expire
+	}
+	return 0
+}
+
+func (x *Entry) GetEncoding() string {
+	if x != nil {
+		return x.Encoding
+	}
+	return ""
+}
+
+type TransferRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Entries       []*Entry               `protobuf:"bytes,2,rep,name=entries,proto3" json:"entries,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TransferRequest) Reset() {
+	*x = TransferRequest{}
+	mi := &file_mycache_mycachepb_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransferRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransferRequest) ProtoMessage() {}
+
+func (x *TransferRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mycache_mycachepb_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransferRequest.ProtoReflect.Descriptor instead.
+func (*TransferRequest) Descriptor() ([]byte, []int) {
+	return file_mycache_mycachepb_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *TransferRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *TransferRequest) GetEntries() []*Entry {
+	if x != nil {
+		return x.Entries
+	}
+	return nil
+}
+
+type TransferResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Accepted      int64                  `protobuf:"varint,1,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TransferResponse) Reset() {
+	*x = TransferResponse{}
+	mi := &file_mycache_mycachepb_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransferResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransferResponse) ProtoMessage() {}
+
+func (x *TransferResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_mycache_mycachepb_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransferResponse.ProtoReflect.Descriptor instead.
+func (*TransferResponse) Descriptor() ([]byte, []int) {
+	return file_mycache_mycachepb_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *TransferResponse) GetAccepted() int64 {
+	if x != nil {
+		return x.Accepted
+	}
+	return 0
+}
+
+type LeaseRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Key           []byte                 `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	Ttl           int64                  `protobuf:"varint,3,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	Wait          int64                  `protobuf:"varint,4,opt,name=wait,proto3" json:"wait,omitempty"`
+	Release       uint64                 `protobuf:"varint,5,opt,name=release,proto3" json:"release,omitempty"`
+	Loaded        bool                   `protobuf:"varint,6,opt,name=loaded,proto3" json:"loaded,omitempty"`
+	Value         []byte                 `protobuf:"bytes,7,opt,name=value,proto3" json:"value,omitempty"`
+	Encoding      string                 `protobuf:"bytes,8,opt,name=encoding,proto3" json:"encoding,omitempty"`
+	Error         *Error                 `protobuf:"bytes,9,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LeaseRequest) Reset() {
+	*x = LeaseRequest{}
+	mi := &file_mycache_mycachepb_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LeaseRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LeaseRequest) ProtoMessage() {}
+
+func (x *LeaseRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_mycache_mycachepb_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LeaseRequest.ProtoReflect.Descriptor instead.
+func (*LeaseRequest) Descriptor() ([]byte, []int) {
+	return file_mycache_mycachepb_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *LeaseRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *LeaseRequest) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *LeaseRequest) GetTtl() int64 {
+	if x != nil {
+		return x.Ttl
+	}
+	return 0
+}
+
+func (x *LeaseRequest) GetWait() int64 {
+	if x != nil {
+		return x.Wait
+	}
+	return 0
+}
+
+func (x *LeaseRequest) GetRelease() uint64 {
+	if x != nil {
+		return x.Release
+	}
+	return 0
+}
+
+func (x *LeaseRequest) GetLoaded() bool {
+	if x != nil {
+		return x.Loaded
+	}
+	return false
+}
+
+func (x *LeaseRequest) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *LeaseRequest) GetEncoding() string {
+	if x != nil {
+		return x.Encoding
+	}
+	return ""
+}
+
+func (x *LeaseRequest) GetError() *Error {
+	if x != nil {
+		return x.Error
+	}
+	return nil
+}
+
+type LeaseResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Loaded        bool                   `protobuf:"varint,2,opt,name=loaded,proto3" json:"loaded,omitempty"`
+	Value         []byte                 `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	Encoding      string                 `protobuf:"bytes,4,opt,name=encoding,proto3" json:"encoding,omitempty"`
+	Error         *Error                 `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LeaseResponse) Reset() {
+	*x = LeaseResponse{}
+	mi := &file_mycache_mycachepb_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LeaseResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LeaseResponse) ProtoMessage() {}
+
+func (x *LeaseResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_mycache_mycachepb_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LeaseResponse.ProtoReflect.Descriptor instead.
+func (*LeaseResponse) Descriptor() ([]byte, []int) {
+	return file_mycache_mycachepb_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *LeaseResponse) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *LeaseResponse) GetLoaded() bool {
+	if x != nil {
+		return x.Loaded
+	}
+	return false
+}
+
+func (x *LeaseResponse) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *LeaseResponse) GetEncoding() string {
+	if x != nil {
+		return x.Encoding
+	}
+	return ""
+}
+
+func (x *LeaseResponse) GetError() *Error {
+	if x != nil {
+		return x.Error
+	}
+	return nil
+}
+
+type Error struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Code          Code                   `protobuf:"varint,1,opt,name=code,proto3,enum=mycachepb.Code" json:"code,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	Retryable     bool                   `protobuf:"varint,3,opt,name=retryable,proto3" json:"retryable,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Error) Reset() {
+	*x = Error{}
+	mi := &file_mycache_mycachepb_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Error) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Error) ProtoMessage() {}
+
+func (x *Error) ProtoReflect() protoreflect.Message {
+	mi := &file_mycache_mycachepb_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Error.ProtoReflect.Descriptor instead.
+func (*Error) Descriptor() ([]byte, []int) {
+	return file_mycache_mycachepb_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Error) GetCode() Code {
+	if x != nil {
+		return x.Code
+	}
+	return Code_UNKNOWN
+}
+
+func (x *Error) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *Error) GetRetryable() bool {
+	if x != nil {
+		return x.Retryable
+	}
+	return false
+}
+
 var File_mycache_mycachepb_proto protoreflect.FileDescriptor
 
 const file_mycache_mycachepb_proto_rawDesc = "" +
@@ -124,12 +737,65 @@ const file_mycache_mycachepb_proto_rawDesc = "" +
 	"\x17mycache/mycachepb.proto\x12\tmycachepb\"1\n" +
 	"\aRequest\x12\x14\n" +
 	"\x05group\x18\x01 \x01(\tR\x05group\x12\x10\n" +
-	"\x03key\x18\x02 \x01(\tR\x03key\" \n" +
+	"\x03key\x18\x02 \x01(\tR\x03key\"~\n" +
 	"\bResponse\x12\x14\n" +
-	"\x05value\x18\x01 \x01(\fR\x05value2>\n" +
+	"\x05value\x18\x01 \x01(\fR\x05value\x12\x1a\n" +
+	"\bencoding\x18\x02 \x01(\tR\bencoding\x12\x10\n" +
+	"\x03ttl\x18\x03 \x01(\x03R\x03ttl\x12\x18\n" +
+	"\aversion\x18\x04 \x01(\rR\aversion\x12\x14\n" +
+	"\x05flags\x18\x05 \x01(\rR\x05flags\"]\n" +
+	"\n" +
+	"GetRequest\x12\x14\n" +
+	"\x05group\x18\x01 \x01(\tR\x05group\x12\x10\n" +
+	"\x03key\x18\x02 \x01(\fR\x03key\x12'\n" +
+	"\x0faccept_encoding\x18\x03 \x03(\tR\x0eacceptEncoding\"c\n" +
+	"\x05Entry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\fR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\fR\x05value\x12\x16\n" +
+	"\x06expire\x18\x03 \x01(\x03R\x06expire\x12\x1a\n" +
+	"\bencoding\x18\x04 \x01(\tR\bencoding\"S\n" +
+	"\x0fTransferRequest\x12\x14\n" +
+	"\x05group\x18\x01 \x01(\tR\x05group\x12*\n" +
+	"\aentries\x18\x02 \x03(\v2\x10.mycachepb.EntryR\aentries\".\n" +
+	"\x10TransferResponse\x12\x1a\n" +
+	"\baccepted\x18\x01 \x01(\x03R\baccepted\"\xe8\x01\n" +
+	"\fLeaseRequest\x12\x14\n" +
+	"\x05group\x18\x01 \x01(\tR\x05group\x12\x10\n" +
+	"\x03key\x18\x02 \x01(\fR\x03key\x12\x10\n" +
+	"\x03ttl\x18\x03 \x01(\x03R\x03ttl\x12\x12\n" +
+	"\x04wait\x18\x04 \x01(\x03R\x04wait\x12\x18\n" +
+	"\arelease\x18\x05 \x01(\x04R\arelease\x12\x16\n" +
+	"\x06loaded\x18\x06 \x01(\bR\x06loaded\x12\x14\n" +
+	"\x05value\x18\a \x01(\fR\x05value\x12\x1a\n" +
+	"\bencoding\x18\b \x01(\tR\bencoding\x12&\n" +
+	"\x05error\x18\t \x01(\v2\x10.mycachepb.ErrorR\x05error\"\x91\x01\n" +
+	"\rLeaseResponse\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\x12\x16\n" +
+	"\x06loaded\x18\x02 \x01(\bR\x06loaded\x12\x14\n" +
+	"\x05value\x18\x03 \x01(\fR\x05value\x12\x1a\n" +
+	"\bencoding\x18\x04 \x01(\tR\bencoding\x12&\n" +
+	"\x05error\x18\x05 \x01(\v2\x10.mycachepb.ErrorR\x05error\"d\n" +
+	"\x05Error\x12#\n" +
+	"\x04code\x18\x01 \x01(\x0e2\x0f.mycachepb.CodeR\x04code\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12\x1c\n" +
+	"\tretryable\x18\x03 \x01(\bR\tretryable*\x1f\n" +
+	"\x04Flag\x12\f\n" +
+	"\bNO_FLAGS\x10\x00\x12\t\n" +
+	"\x05STALE\x10\x01*\x96\x01\n" +
+	"\x04Code\x12\v\n" +
+	"\aUNKNOWN\x10\x00\x12\r\n" +
+	"\tNOT_FOUND\x10\x01\x12\x11\n" +
+	"\rNO_SUCH_GROUP\x10\x02\x12\x0f\n" +
+	"\vBAD_REQUEST\x10\x03\x12\x13\n" +
+	"\x0fUNAUTHENTICATED\x10\x04\x12\r\n" +
+	"\tFORBIDDEN\x10\x05\x12\x0f\n" +
+	"\vUNAVAILABLE\x10\x06\x12\v\n" +
+	"\aTIMEOUT\x10\a\x12\f\n" +
+	"\bINTERNAL\x10\b2\x85\x01\n" +
 	"\n" +
 	"GroupCache\x120\n" +
-	"\x03Get\x12\x12.mycachepb.Request\x1a\x13.mycachepb.Response\"\x00B\rZ\v./mycachepbb\x06proto3"
+	"\x03Get\x12\x12.mycachepb.Request\x1a\x13.mycachepb.Response\"\x00\x12E\n" +
+	"\bTransfer\x12\x1a.mycachepb.TransferRequest\x1a\x1b.mycachepb.TransferResponse\"\x00B\rZ\v./mycachepbb\x06proto3"
 
 var (
 	file_mycache_mycachepb_proto_rawDescOnce sync.Once
@@ -143,19 +809,35 @@ func file_mycache_mycachepb_proto_rawDescGZIP() []byte {
 	return file_mycache_mycachepb_proto_rawDescData
 }
 
-var file_mycache_mycachepb_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_mycache_mycachepb_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_mycache_mycachepb_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
 var file_mycache_mycachepb_proto_goTypes = []any{
-	(*Request)(nil),  // 0: mycachepb.Request
-	(*Response)(nil), // 1: mycachepb.Response
+	(Flag)(0),                // 0: mycachepb.Flag
+	(Code)(0),                // 1: mycachepb.Code
+	(*Request)(nil),          // 2: mycachepb.Request
+	(*Response)(nil),         // 3: mycachepb.Response
+	(*GetRequest)(nil),       // 4: mycachepb.GetRequest
+	(*Entry)(nil),            // 5: mycachepb.Entry
+	(*TransferRequest)(nil),  // 6: mycachepb.TransferRequest
+	(*TransferResponse)(nil), // 7: mycachepb.TransferResponse
+	(*LeaseRequest)(nil),     // 8: mycachepb.LeaseRequest
+	(*LeaseResponse)(nil),    // 9: mycachepb.LeaseResponse
+	(*Error)(nil),            // 10: mycachepb.Error
 }
 var file_mycache_mycachepb_proto_depIdxs = []int32{
-	0, // 0: mycachepb.GroupCache.Get:input_type -> mycachepb.Request
-	1, // 1: mycachepb.GroupCache.Get:output_type -> mycachepb.Response
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	5,  // 0: mycachepb.TransferRequest.entries:type_name -> mycachepb.Entry
+	10, // 1: mycachepb.LeaseRequest.error:type_name -> mycachepb.Error
+	10, // 2: mycachepb.LeaseResponse.error:type_name -> mycachepb.Error
+	1,  // 3: mycachepb.Error.code:type_name -> mycachepb.Code
+	2,  // 4: mycachepb.GroupCache.Get:input_type -> mycachepb.Request
+	6,  // 5: mycachepb.GroupCache.Transfer:input_type -> mycachepb.TransferRequest
+	3,  // 6: mycachepb.GroupCache.Get:output_type -> mycachepb.Response
+	7,  // 7: mycachepb.GroupCache.Transfer:output_type -> mycachepb.TransferResponse
+	6,  // [6:8] is the sub-list for method output_type
+	4,  // [4:6] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
 }
 
 func init() { file_mycache_mycachepb_proto_init() }
@@ -168,13 +850,14 @@ func file_mycache_mycachepb_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_mycache_mycachepb_proto_rawDesc), len(file_mycache_mycachepb_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   2,
+			NumEnums:      2,
+			NumMessages:   9,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_mycache_mycachepb_proto_goTypes,
 		DependencyIndexes: file_mycache_mycachepb_proto_depIdxs,
+		EnumInfos:         file_mycache_mycachepb_proto_enumTypes,
 		MessageInfos:      file_mycache_mycachepb_proto_msgTypes,
 	}.Build()
 	File_mycache_mycachepb_proto = out.File
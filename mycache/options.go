@@ -0,0 +1,194 @@
+package mycache
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// An Option configures a Group, see NewGroup. Options with side effects,
+// such as WithDiskCache, take effect once the group's name is reserved in
+// its registry, so that they never touch the files of an existing group of
+// the same name.
+type Option func(g *Group) error
+
+// WithCacheBytes bounds the main cache, which holds the values of the keys
+// this node owns, to cacheBytes. It defaults to 64MB, zero means no limit.
+func WithCacheBytes(cacheBytes int64) Option {
+	return func(g *Group) error {
+		if cacheBytes < 0 {
+			return fmt.Errorf("group %s: negative cache size %d", g.name, cacheBytes)
+		}
+		g.mainCache.cacheBytes = cacheBytes
+		return nil
+	}
+}
+
+// WithHotCacheBytes keeps up to cacheBytes of the values loaded from peers
+// in a separate hot cache, so that they don't evict the values this node
+// owns. Zero, the default, disables it and such values go to the main cache.
+func WithHotCacheBytes(cacheBytes int64) Option {
+	return func(g *Group) error {
+		if cacheBytes < 0 {
+			return fmt.Errorf("group %s: negative hot cache size %d", g.name, cacheBytes)
+		}
+		g.hotCache.cacheBytes = cacheBytes
+		return nil
+	}
+}
+
+// WithTTL sets how long loaded values stay in the cache, see SetTTL.
+func WithTTL(ttl time.Duration) Option {
+	return func(g *Group) error {
+		g.SetTTL(ttl)
+		return nil
+	}
+}
+
+// WithNegativeTTL caches not-found errors for ttl, see SetNegativeTTL.
+func WithNegativeTTL(ttl time.Duration) Option {
+	return func(g *Group) error {
+		g.SetNegativeTTL(ttl)
+		return nil
+	}
+}
+
+// WithStaleWhileRevalidate serves expired values while they are refreshed,
+// see SetStaleWhileRevalidate.
+func WithStaleWhileRevalidate(maxStale time.Duration) Option {
+	return func(g *Group) error {
+		g.SetStaleWhileRevalidate(maxStale)
+		return nil
+	}
+}
+
+// WithRefreshAhead refreshes values read close to their expiry, see
+// SetRefreshAhead.
+func WithRefreshAhead(window time.Duration) Option {
+	return func(g *Group) error {
+		g.SetRefreshAhead(window)
+		return nil
+	}
+}
+
+// WithRefreshAheadMinHits sets how often a value must be hit to be refreshed
+// ahead, see SetRefreshAheadMinHits.
+func WithRefreshAheadMinHits(n int) Option {
+	return func(g *Group) error {
+		g.SetRefreshAheadMinHits(n)
+		return nil
+	}
+}
+
+// WithPeers sets the PeerPicker of the group, see RegisterPeers.
+func WithPeers(peers PeerPicker) Option {
+	return func(g *Group) error {
+		if g.peers != nil {
+			return errors.New("mycache: peers set more than once")
+		}
+		g.RegisterPeers(peers)
+		return nil
+	}
+}
+
+// WithStatsHook calls fn with the group's name and stats every interval,
+// e.g. to export them, until the group is deleted.
+func WithStatsHook(interval time.Duration, fn func(group string, stats *Stats)) Option {
+	return func(g *Group) error {
+		if interval <= 0 {
+			return fmt.Errorf("group %s: stats hook interval must be positive", g.name)
+		}
+		g.statsHook = &statsHook{interval: interval, fn: fn}
+		return nil
+	}
+}
+
+// WithLoadLimit limits the concurrent loads from the getter, see SetLoadLimit.
+func WithLoadLimit(opts LoadLimitOptions) Option {
+	return func(g *Group) error {
+		g.SetLoadLimit(opts)
+		return nil
+	}
+}
+
+// WithHedging hedges slow loads from peers, see SetHedging.
+func WithHedging(opts HedgeOptions) Option {
+	return func(g *Group) error {
+		g.SetHedging(opts)
+		return nil
+	}
+}
+
+// WithRetries retries failed loads from peers, see SetRetries.
+func WithRetries(opts RetryOptions) Option {
+	return func(g *Group) error {
+		g.SetRetries(opts)
+		return nil
+	}
+}
+
+// WithLoadLease deduplicates loads across the cluster, see SetLoadLease.
+func WithLoadLease(opts LeaseOptions) Option {
+	return func(g *Group) error {
+		g.SetLoadLease(opts)
+		return nil
+	}
+}
+
+// WithCompression compresses the stored values, see SetCompression.
+func WithCompression(c Compressor, threshold int) Option {
+	return func(g *Group) error {
+		g.SetCompression(c, threshold)
+		return nil
+	}
+}
+
+// WithDiskCache adds a disk tier to the main cache, see EnableDiskCache.
+func WithDiskCache(dir string, maxBytes int64) Option {
+	return func(g *Group) error {
+		g.deferSetup(func() error {
+			if err := g.EnableDiskCache(dir, maxBytes); err != nil {
+				return fmt.Errorf("group %s: %w", g.name, err)
+			}
+			return nil
+		})
+		return nil
+	}
+}
+
+// WithKeyFilter rejects keys that don't exist, see EnableKeyFilter.
+func WithKeyFilter(src KeySource, expected uint64, fpRate float64, interval time.Duration) Option {
+	return func(g *Group) error {
+		g.deferSetup(func() error {
+			return g.EnableKeyFilter(src, expected, fpRate, interval)
+		})
+		return nil
+	}
+}
+
+// deferSetup defers fn, the work of an option with side effects, until
+// NewGroup has reserved the group's name.
+func (g *Group) deferSetup(fn func() error) {
+	g.setup = append(g.setup, fn)
+}
+
+// statsHook is a stats hook and how often it is called, see WithStatsHook.
+type statsHook struct {
+	interval time.Duration
+	fn       func(group string, stats *Stats)
+}
+
+// reportStats calls fn with the group's stats every interval until the
+// group is deleted.
+func (g *Group) reportStats(interval time.Duration, fn func(group string, stats *Stats)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.done:
+			return
+		case <-ticker.C:
+			fn(g.name, &g.Stats)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package mycache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	pb "mycache/mycachepb"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// The peer protocol has two versions. Version 1 gets a value with
+// GET <basePath><group>/<key>. Version 2 posts a GetRequest to
+// <basePath>_v2/get, which carries keys of any bytes, and fills in the
+// metadata of the Response. Peers send their version in protocolHeader with
+// every response. Clients use v2 and fall back to v1 for peers that don't
+// support it, so that mixed-version clusters keep working.
+const (
+	protocolVersion = 2
+	protocolHeader  = "X-Mycache-Protocol"
+	v2GetPath       = "_v2/get"
+)
+
+// v1RetryInterval is how long a peer that doesn't support protocol v2 is
+// asked with v1 before v2 is tried again: the peer may be upgraded, or the
+// 404 may have come from a proxy or a server restarting.
+const v1RetryInterval = time.Minute
+
+// errNoV2 is returned by getV2 for peers that don't support protocol v2.
+var errNoV2 = errors.New("peer does not support protocol v2")
+
+// serveGetV2 handles a v2 get request.
+func (p *HTTPPool) serveGetV2(w http.ResponseWriter, r *http.Request, caller string) {
+	if r.Method != http.MethodPost {
+		writeError(w, fmt.Errorf("%w: method not allowed", errBadRequest))
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %v", errBadRequest, err))
+		return
+	}
+	req := &pb.GetRequest{}
+	if err = proto.Unmarshal(body, req); err != nil {
+		writeError(w, fmt.Errorf("%w: %v", errBadRequest, err))
+		return
+	}
+	if !p.allowed(caller, req.GetGroup(), PermRead) {
+		writeError(w, fmt.Errorf("%w: %q may not access group %s", ErrForbidden, caller, req.GetGroup()))
+		return
+	}
+	group := p.registry.Group(req.GetGroup())
+	if group == nil {
+		writeError(w, fmt.Errorf("%w: %s", ErrNoSuchGroup, req.GetGroup()))
+		return
+	}
+	done, ok := p.admit(w, r, caller, group, string(req.GetKey()))
+	if !ok {
+		return
+	}
+	defer done()
+	writeValue(w, group, string(req.GetKey()), req.GetAcceptEncoding())
+}
+
+// writeValue gets key from group and writes it as a protobuf Response,
+// compressed if the caller accepts the compression it is stored with.
+func writeValue(w http.ResponseWriter, group *Group, key string, accepted []string) {
+	view, err := group.get(key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// the version is of the value itself, however it is sent
+	plain, err := view.decompress()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res := &pb.Response{Value: plain.data(), Version: crc32.Checksum(plain.data(), castagnoli)}
+	if view.z != nil && slices.Contains(accepted, view.z.Name()) {
+		res.Value, res.Encoding = view.data(), view.z.Name()
+	}
+	if now := time.Now(); view.expired(now) {
+		res.Flags |= uint32(pb.Flag_STALE)
+	} else if !view.e.IsZero() {
+		res.Ttl = int64(view.e.Sub(now))
+	}
+
+	// Write the value to the response body as a protobuf message
+	body, err := proto.Marshal(res)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+// getV2 gets a value with protocol v2. It returns errNoV2 if the peer
+// doesn't support it.
+func (h *httpGetter) getV2(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	body, err := proto.Marshal(&pb.GetRequest{
+		Group:          in.GetGroup(),
+		Key:            []byte(in.GetKey()),
+		AcceptEncoding: compressorNames(),
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.baseURL+v2GetPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	res, err := send(h.client, h.auth, req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		// v1 peers take the path for a group and key
+		if res.StatusCode == http.StatusNotFound && res.Header.Get(protocolHeader) == "" {
+			return errNoV2
+		}
+		h.backOff(res)
+		return responseError(res)
+	}
+	return readResponse(res, out)
+}
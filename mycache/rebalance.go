@@ -0,0 +1,254 @@
+package mycache
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"mycache/consistenthash"
+	pb "mycache/mycachepb"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	defaultTransferBatch  = 100
+	defaultRebalanceDelay = time.Second
+)
+
+// Rebalancer moves cached keys to their new owners after the ring of an
+// HTTPPool changes, so the new owners don't send a burst of misses to the
+// Getter. Keys are pushed in batches through the transfer RPC.
+type Rebalancer struct {
+	pool *HTTPPool
+	// BatchSize is the number of entries sent per transfer request.
+	BatchSize int
+	// Rate is the maximum number of entries sent per second, zero means unlimited.
+	Rate int
+	// Delay is how long to wait after a ring change before rebalancing,
+	// so a burst of changes is handled at once.
+	Delay time.Duration
+
+	mu sync.Mutex
+	// base is the ring the cache contents were last balanced for.
+	base  *consistenthash.Map
+	timer *time.Timer
+	// running serializes Rebalance calls.
+	running sync.Mutex
+}
+
+// NewRebalancer creates a Rebalancer and registers it with pool.
+func NewRebalancer(pool *HTTPPool) *Rebalancer {
+	r := &Rebalancer{
+		pool:      pool,
+		BatchSize: defaultTransferBatch,
+		Delay:     defaultRebalanceDelay,
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	r.base = pool.peers
+	pool.rebalancer = r
+	return r
+}
+
+// ringChanged schedules a rebalance, it is called with pool.mu held.
+func (r *Rebalancer) ringChanged() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.timer = time.AfterFunc(r.Delay, func() {
+		if err := r.Rebalance(context.Background()); err != nil {
+			r.pool.Log("rebalance: %v", err)
+		}
+	})
+}
+
+// Rebalance sends the keys this node owned on the previous ring and another
+// peer owns on the current one to that peer.
+func (r *Rebalancer) Rebalance(ctx context.Context) error {
+	r.running.Lock()
+	defer r.running.Unlock()
+
+	r.mu.Lock()
+	base := r.base
+	r.mu.Unlock()
+	current, getters := r.pool.ring()
+
+	sent, err := r.pool.sendMoved(ctx, base, current, getters, 0, r.BatchSize, r.Rate)
+	if sent > 0 {
+		r.pool.Log("rebalanced %d keys", sent)
+	}
+	if err == nil {
+		r.mu.Lock()
+		r.base = current
+		r.mu.Unlock()
+	}
+	return err
+}
+
+// ring returns the current ring and the getters of its peers.
+func (p *HTTPPool) ring() (*consistenthash.Map, map[string]*httpGetter) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.peers, p.httpGetter
+}
+
+// sendMoved sends the cached entries that base assigned to p and current
+// assigns to another peer to their new owners. At most limit entries per
+// group are considered, most recently used first, zero means all of them.
+// A nil base assigns every key to p.
+func (p *HTTPPool) sendMoved(ctx context.Context, base, current *consistenthash.Map, getters map[string]*httpGetter, limit, batch, rate int) (int, error) {
+	if limit <= 0 {
+		limit = math.MaxInt
+	}
+	if batch <= 0 {
+		batch = defaultTransferBatch
+	}
+	if current == nil {
+		return 0, nil
+	}
+
+	pace := &pacer{rate: rate}
+	sent := 0
+	var firstErr error
+	for _, g := range p.registry.Groups() {
+		// group the moved entries by their new owner
+		moved := make(map[string][]*pb.Entry)
+		for _, e := range g.mainCache.hottest(limit) {
+			if base != nil && base.Get(e.key) != p.self {
+				continue
+			}
+			if owner := current.Get(e.key); owner != "" && owner != p.self {
+				entry := &pb.Entry{
+					Key:    []byte(e.key),
+					Value:  e.value.data(),
+					Expire: unixNano(e.value.e),
+				}
+				if e.value.z != nil {
+					entry.Encoding = e.value.z.Name()
+				}
+				moved[owner] = append(moved[owner], entry)
+			}
+		}
+
+		for owner, entries := range moved {
+			for len(entries) > 0 {
+				n := min(batch, len(entries))
+				if err := pace.wait(ctx, n); err != nil {
+					return sent, err
+				}
+				req := &pb.TransferRequest{Group: g.name, Entries: entries[:n]}
+				if err := getters[owner].transfer(ctx, req, &pb.TransferResponse{}); err != nil {
+					p.Log("transfer %d keys of %s to %s: %v", n, g.name, owner, err)
+					if firstErr == nil {
+						firstErr = err
+					}
+				} else {
+					sent += n
+				}
+				entries = entries[n:]
+			}
+		}
+	}
+	return sent, firstErr
+}
+
+// serveTransfer stores the entries a peer moved to this node, if caller may write to their group.
+func (p *HTTPPool) serveTransfer(w http.ResponseWriter, r *http.Request, caller string) {
+	if !p.peerWritesAllowed() {
+		writeError(w, fmt.Errorf("%w: transfers need authenticated peers", ErrForbidden))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, fmt.Errorf("%w: method not allowed", errBadRequest))
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %v", errBadRequest, err))
+		return
+	}
+	req := &pb.TransferRequest{}
+	if err = proto.Unmarshal(body, req); err != nil {
+		writeError(w, fmt.Errorf("%w: %v", errBadRequest, err))
+		return
+	}
+	if !p.allowed(caller, req.GetGroup(), PermWrite) {
+		writeError(w, fmt.Errorf("%w: %q may not write to group %s", ErrForbidden, caller, req.GetGroup()))
+		return
+	}
+	group := p.registry.Group(req.GetGroup())
+	if group == nil {
+		writeError(w, fmt.Errorf("%w: %s", ErrNoSuchGroup, req.GetGroup()))
+		return
+	}
+
+	now := time.Now()
+	var accepted int64
+	for _, e := range req.GetEntries() {
+		value := ByteView{b: e.GetValue()}
+		if e.GetExpire() != 0 {
+			value.e = time.Unix(0, e.GetExpire())
+		}
+		if value.expired(now) {
+			continue
+		}
+		if value, err = group.received(value, e.GetEncoding()); err != nil {
+			p.Log("transfer %s/%q: %v", group.name, e.GetKey(), err)
+			continue
+		}
+		group.populateCache(string(e.GetKey()), value)
+		accepted++
+	}
+
+	body, err = proto.Marshal(&pb.TransferResponse{Accepted: accepted})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+// unixNano returns t in unix nanoseconds, or 0 for the zero time.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// pacer spaces out work so that no more than rate units are done per second.
+// A zero rate never waits. It is not safe for concurrent use.
+type pacer struct {
+	rate int
+	next time.Time
+}
+
+// wait blocks until n more units may be done or ctx is done.
+func (p *pacer) wait(ctx context.Context, n int) error {
+	if p.rate <= 0 {
+		return nil
+	}
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	d := p.next.Sub(now)
+	p.next = p.next.Add(time.Duration(n) * time.Second / time.Duration(p.rate))
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,98 @@
+package mycache
+
+import (
+	"fmt"
+	"sync"
+)
+
+// A Registry holds cache groups by name. An HTTPPool serves the groups of
+// one registry, see HTTPPoolOptions, so that independent clusters can live
+// in one process, each with its own registry and pool.
+type Registry struct {
+	mu     sync.RWMutex
+	groups map[string]*Group
+	// reserved holds the names of the groups being created, see reserve.
+	reserved map[string]bool
+}
+
+// DefaultRegistry holds the groups created without WithRegistry, and is
+// served by the pools created without a Registry.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{groups: make(map[string]*Group), reserved: make(map[string]bool)}
+}
+
+// WithRegistry registers the group in r instead of DefaultRegistry.
+func WithRegistry(r *Registry) Option {
+	return func(g *Group) error {
+		if r == nil {
+			return fmt.Errorf("group %s: nil registry", g.name)
+		}
+		g.registry = r
+		return nil
+	}
+}
+
+// Group returns the group with the specified name, or nil if there is none.
+func (r *Registry) Group(name string) *Group {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.groups[name]
+}
+
+// Groups returns every group of the registry.
+func (r *Registry) Groups() []*Group {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	gs := make([]*Group, 0, len(r.groups))
+	for _, g := range r.groups {
+		gs = append(gs, g)
+	}
+	return gs
+}
+
+// Delete unregisters the group with the specified name, stops its
+// background work and drops its cached values so that their memory can be
+// reclaimed. Loads in flight complete but their values are not cached.
+// It reports whether there was such a group.
+func (r *Registry) Delete(name string) bool {
+	r.mu.Lock()
+	g, ok := r.groups[name]
+	delete(r.groups, name)
+	r.mu.Unlock()
+	if !ok {
+		return false
+	}
+	g.close()
+	return true
+}
+
+// reserve reserves name for a group being created, unless the registry has
+// a group of that name or it is reserved already. The group is then either
+// added or the name released.
+func (r *Registry) reserve(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.groups[name]; ok || r.reserved[name] {
+		return fmt.Errorf("%w: %s", ErrGroupExists, name)
+	}
+	r.reserved[name] = true
+	return nil
+}
+
+// release releases the reserved name of a group that failed to be created.
+func (r *Registry) release(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.reserved, name)
+}
+
+// add registers g under its reserved name.
+func (r *Registry) add(g *Group) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.reserved, g.name)
+	r.groups[g.name] = g
+}
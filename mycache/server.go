@@ -0,0 +1,165 @@
+package mycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const defaultDrainTimeout = 30 * time.Second
+
+// Server serves an HTTPPool and takes it out of the cluster gracefully on shutdown.
+type Server struct {
+	pool *HTTPPool
+	srv  *http.Server
+	// DrainTimeout bounds how long Run waits for in-flight loads on shutdown.
+	DrainTimeout time.Duration
+	// HandoffKeys is the number of most recently used keys per group that are
+	// handed to their new owners on shutdown, zero disables the handoff.
+	HandoffKeys int
+	// CertFile and KeyFile enable TLS when set. TLS is also enabled when
+	// the pool has a TLSConfig, see HTTPPoolOptions.
+	CertFile, KeyFile string
+	// SnapshotDir, if set, is where every group is snapshotted on shutdown
+	// and restored from on start, one "<group>.snap" file per group.
+	SnapshotDir string
+}
+
+// NewServer creates a server for pool listening on addr, e.g. ":8001".
+func NewServer(pool *HTTPPool, addr string) *Server {
+	return &Server{
+		pool:         pool,
+		srv:          &http.Server{Addr: addr, Handler: pool, TLSConfig: pool.tlsConfig, Protocols: serverProtocols()},
+		DrainTimeout: defaultDrainTimeout,
+	}
+}
+
+// ListenAndServe listens on the server address, asks the peers to add this
+// node to their rings and serves until Shutdown is called.
+// Like http.Server it returns http.ErrServerClosed after Shutdown.
+func (s *Server) ListenAndServe() error {
+	if s.SnapshotDir != "" {
+		s.restore()
+	}
+	l, err := net.Listen("tcp", s.srv.Addr)
+	if err != nil {
+		return err
+	}
+	go s.pool.Join(context.Background())
+	if s.CertFile != "" || s.srv.TLSConfig != nil {
+		return s.srv.ServeTLS(l, s.CertFile, s.KeyFile)
+	}
+	return s.srv.Serve(l)
+}
+
+// Shutdown takes the node out of the cluster: it stops accepting peer
+// requests, asks the peers to take it off their rings, hands off the hottest
+// keys if HandoffKeys is set and waits for in-flight loads of every group
+// before closing the listener. It gives up once ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.pool.Log("shutting down")
+	s.pool.Leave(ctx)
+	if s.HandoffKeys > 0 {
+		if err := s.pool.Handoff(ctx, s.HandoffKeys); err != nil {
+			s.pool.Log("handoff: %v", err)
+		}
+	}
+
+	var errs []error
+	for _, g := range s.pool.registry.Groups() {
+		if err := g.Drain(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := s.srv.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if s.SnapshotDir != "" {
+		if err := s.snapshot(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Server) snapshotPath(g *Group) string {
+	return filepath.Join(s.SnapshotDir, url.PathEscape(g.name)+".snap")
+}
+
+// restore loads the snapshot of every group that has one. A missing or
+// corrupt snapshot only means that group starts cold.
+func (s *Server) restore() {
+	for _, g := range s.pool.registry.Groups() {
+		f, err := os.Open(s.snapshotPath(g))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			s.pool.Log("restore %s: %v", g.name, err)
+			continue
+		}
+		if err = g.Restore(f); err != nil {
+			s.pool.Log("restore %s: %v", g.name, err)
+		}
+		f.Close()
+	}
+}
+
+// snapshot writes the snapshot of every group, replacing the old files
+// only once the new ones are complete.
+func (s *Server) snapshot() error {
+	var errs []error
+	for _, g := range s.pool.registry.Groups() {
+		if err := writeSnapshot(g, s.snapshotPath(g)); err != nil {
+			errs = append(errs, fmt.Errorf("snapshot %s: %w", g.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func writeSnapshot(g *Group, path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err = g.Snapshot(f); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Run serves until the process receives SIGINT or SIGTERM, then shuts the
+// server down, waiting at most DrainTimeout.
+func (s *Server) Run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.ListenAndServe()
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.DrainTimeout)
+	defer cancel()
+	return s.Shutdown(ctx)
+}
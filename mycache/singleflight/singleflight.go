@@ -1,47 +1,193 @@
 package singleflight
 
-import "sync"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// errGoexit marks a call whose function called runtime.Goexit.
+var errGoexit = errors.New("runtime.Goexit was called")
+
+// A panicError is a panic of the function of a call, passed on to every
+// caller along with the stack it was raised at.
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (p *panicError) Error() string {
+	return fmt.Sprintf("%v\n\n%s", p.value, p.stack)
+}
+
+func (p *panicError) Unwrap() error {
+	err, ok := p.value.(error)
+	if !ok {
+		return nil
+	}
+	return err
+}
+
+func newPanicError(v interface{}) error {
+	stack := debug.Stack()
+	// the first line is "goroutine N [status]:", drop it as it is not
+	// where the panic is passed on
+	if line := bytes.IndexByte(stack, '\n'); line >= 0 {
+		stack = stack[line+1:]
+	}
+	return &panicError{value: v, stack: stack}
+}
 
 // wg is used to wait for the goroutine to complete.
 // val holds the value returned by the function call.
 // err holds any error that occurred during the function call.
-type call struct {
+// dups counts the callers sharing the call, chans are those of DoChan.
+type call[V any] struct {
 	wg  sync.WaitGroup
-	val interface{}
+	val V
 	err error
+
+	dups  int
+	chans []chan<- TypedResult[V]
+}
+
+// TypedResult holds the results of Do, so they can be passed on a channel.
+type TypedResult[V any] struct {
+	Val    V
+	Err    error
+	Shared bool
 }
 
-type Group struct {
+// TypedGroup deduplicates the calls of functions returning values of type
+// V by keys of type K. The zero value is ready to use.
+type TypedGroup[K comparable, V any] struct {
 	mu sync.Mutex
-	m  map[string]*call
+	m  map[K]*call[V]
 }
 
+// Group is a TypedGroup of interface{} values by string keys.
+type Group = TypedGroup[string, interface{}]
+
+// Result is a TypedResult of interface{} values, as Group.DoChan returns.
+type Result = TypedResult[interface{}]
+
 // Do executes and returns the result of the function `fn` if the given `key` is not already being processed.
 // If the `key` is being processed by another goroutine, `Do` waits for that goroutine to complete and returns its result.
-// The result of the function `fn` is stored in the cache for future use.
+// shared reports whether the result was given to more than one caller.
+// If `fn` panics, the panic is raised again in every caller, and if it calls runtime.Goexit, so does every caller.
 // The `Do` method is safe for concurrent use.
-func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+func (g *TypedGroup[K, V]) Do(key K, fn func() (V, error)) (v V, err error, shared bool) {
 	g.mu.Lock()
 	if g.m == nil {
-		g.m = make(map[string]*call)
+		g.m = make(map[K]*call[V])
 	}
 	if c, ok := g.m[key]; ok {
+		c.dups++
 		g.mu.Unlock()
 		c.wg.Wait()
-		return c.val, c.err
+
+		if e, ok := c.err.(*panicError); ok {
+			panic(e)
+		} else if c.err == errGoexit {
+			runtime.Goexit()
+		}
+		return c.val, c.err, true
 	}
 
-	c := new(call)
+	c := new(call[V])
 	c.wg.Add(1)
 	g.m[key] = c
 	g.mu.Unlock()
 
-	c.val, c.err = fn()
-	c.wg.Done()
+	g.doCall(c, key, fn)
+	return c.val, c.err, c.dups > 0
+}
 
+// DoChan is like Do but returns a channel that receives the result once it
+// is ready, so the caller need not wait for it. The channel is not closed.
+// If `fn` panics, the process crashes, as there is no caller to raise the
+// panic in. If it calls runtime.Goexit, the result holds an error.
+func (g *TypedGroup[K, V]) DoChan(key K, fn func() (V, error)) <-chan TypedResult[V] {
+	ch := make(chan TypedResult[V], 1)
 	g.mu.Lock()
-	delete(g.m, key)
+	if g.m == nil {
+		g.m = make(map[K]*call[V])
+	}
+	if c, ok := g.m[key]; ok {
+		c.dups++
+		c.chans = append(c.chans, ch)
+		g.mu.Unlock()
+		return ch
+	}
+
+	c := &call[V]{chans: []chan<- TypedResult[V]{ch}}
+	c.wg.Add(1)
+	g.m[key] = c
 	g.mu.Unlock()
 
-	return c.val, c.err
+	go g.doCall(c, key, fn)
+	return ch
+}
+
+// doCall runs the call c of key, recovering a panic of fn, and hands its
+// result to every caller.
+func (g *TypedGroup[K, V]) doCall(c *call[V], key K, fn func() (V, error)) {
+	normalReturn := false
+	recovered := false
+
+	// Goexit can't be recovered from, so the deferred func tells it from a
+	// panic by whether fn returned or the recover below ran.
+	defer func() {
+		if !normalReturn && !recovered {
+			c.err = errGoexit
+		}
+
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		c.wg.Done()
+		if g.m[key] == c {
+			delete(g.m, key)
+		}
+
+		if e, ok := c.err.(*panicError); ok {
+			if len(c.chans) > 0 {
+				// crash rather than leave the channels waiting forever
+				go panic(e)
+				select {} // keep this goroutine's stack in the crash dump
+			}
+			panic(e)
+		}
+		// on Goexit, callers of Do exit too, DoChan's get errGoexit
+		for _, ch := range c.chans {
+			ch <- TypedResult[V]{c.val, c.err, c.dups > 0}
+		}
+	}()
+
+	func() {
+		defer func() {
+			if !normalReturn {
+				if r := recover(); r != nil {
+					c.err = newPanicError(r)
+				}
+			}
+		}()
+
+		c.val, c.err = fn()
+		normalReturn = true
+	}()
+
+	if !normalReturn {
+		recovered = true
+	}
+}
+
+// Forget makes the group forget key: the next call of Do or DoChan for it
+// runs its function instead of waiting for the call in flight.
+func (g *TypedGroup[K, V]) Forget(key K) {
+	g.mu.Lock()
+	delete(g.m, key)
+	g.mu.Unlock()
 }
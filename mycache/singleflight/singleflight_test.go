@@ -0,0 +1,276 @@
+package singleflight
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	var g Group
+	v, err, shared := g.Do("key", func() (interface{}, error) {
+		return "bar", nil
+	})
+	if got, want := fmt.Sprintf("%v (%T)", v, v), "bar (string)"; got != want {
+		t.Errorf("Do = %v; want %v", got, want)
+	}
+	if err != nil || shared {
+		t.Errorf("Do error = %v, shared = %v", err, shared)
+	}
+}
+
+func TestDoTyped(t *testing.T) {
+	var g TypedGroup[int, []byte]
+	v, err, _ := g.Do(1, func() ([]byte, error) {
+		return []byte("bar"), nil
+	})
+	if string(v) != "bar" || err != nil {
+		t.Errorf("Do = %q, %v; want bar", v, err)
+	}
+	if res := <-g.DoChan(2, func() ([]byte, error) { return nil, nil }); res.Val != nil || res.Err != nil {
+		t.Errorf("DoChan = %+v; want the zero value", res)
+	}
+}
+
+func TestDoErr(t *testing.T) {
+	var g Group
+	someErr := errors.New("some error")
+	v, err, _ := g.Do("key", func() (interface{}, error) {
+		return nil, someErr
+	})
+	if err != someErr {
+		t.Errorf("Do error = %v; want someErr", err)
+	}
+	if v != nil {
+		t.Errorf("unexpected non-nil value %#v", v)
+	}
+}
+
+func TestDoDupSuppress(t *testing.T) {
+	var g Group
+	var wg1, wg2 sync.WaitGroup
+	c := make(chan string, 1)
+	const n = 10
+	var calls int32
+	fn := func() (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// first invocation
+			wg1.Done()
+		}
+		v := <-c
+		c <- v // pump; make available for any future calls
+
+		waitCallers(&g, "key", n) // let the other goroutines enter Do
+
+		return v, nil
+	}
+
+	wg1.Add(1)
+	var sharedCalls int32
+	for i := 0; i < n; i++ {
+		wg1.Add(1)
+		wg2.Add(1)
+		go func() {
+			defer wg2.Done()
+			wg1.Done()
+			v, err, shared := g.Do("key", fn)
+			if err != nil {
+				t.Errorf("Do error: %v", err)
+				return
+			}
+			if s, _ := v.(string); s != "bar" {
+				t.Errorf("Do = %T %v; want %q", v, v, "bar")
+			}
+			if shared {
+				atomic.AddInt32(&sharedCalls, 1)
+			}
+		}()
+	}
+	wg1.Wait()
+	// At least one goroutine is in fn now and all of them have at
+	// least reached the line before the Do.
+	c <- "bar"
+	wg2.Wait()
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("number of calls = %d; want 1", got)
+	}
+	if got := atomic.LoadInt32(&sharedCalls); got != n {
+		t.Errorf("shared results = %d; want %d", got, n)
+	}
+}
+
+// waitCallers waits until n callers share the call of key.
+func waitCallers(g *Group, key string, n int) {
+	for {
+		g.mu.Lock()
+		c, ok := g.m[key]
+		joined := ok && c.dups+1 >= n
+		g.mu.Unlock()
+		if joined {
+			return
+		}
+		runtime.Gosched()
+	}
+}
+
+func TestDoChan(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	var calls int32
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "bar", nil
+	}
+	ch1 := g.DoChan("key", fn)
+	ch2 := g.DoChan("key", fn)
+	close(release)
+	for _, ch := range []<-chan Result{ch1, ch2} {
+		select {
+		case res := <-ch:
+			if res.Val != "bar" || res.Err != nil || !res.Shared {
+				t.Errorf("DoChan = %+v; want a shared bar", res)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("DoChan result not received")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("number of calls = %d; want 1", calls)
+	}
+}
+
+func TestForget(t *testing.T) {
+	var g Group
+	first := make(chan struct{})
+	release := make(chan struct{})
+	go g.Do("key", func() (interface{}, error) {
+		close(first)
+		<-release
+		return 1, nil
+	})
+	<-first
+
+	g.Forget("key")
+	v, _, shared := g.Do("key", func() (interface{}, error) {
+		return 2, nil
+	})
+	if v != 2 || shared {
+		t.Errorf("Do after Forget = %v, shared %v; want 2 from a new call", v, shared)
+	}
+
+	// the forgotten call must not remove the new call of key when it ends
+	second := make(chan struct{})
+	ch := g.DoChan("key", func() (interface{}, error) {
+		close(second)
+		<-release
+		return 3, nil
+	})
+	<-second
+	close(release)
+	if res := <-ch; res.Val != 3 {
+		t.Errorf("DoChan after Forget = %v; want 3", res.Val)
+	}
+}
+
+func TestPanicDo(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-release
+		panic("invalid memory address or nil pointer dereference")
+	}
+
+	const n = 5
+	panicCount := int32(0)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if err := recover(); err != nil {
+					atomic.AddInt32(&panicCount, 1)
+				}
+			}()
+			g.Do("key", fn)
+		}()
+	}
+	waitCallers(&g, "key", n)
+	close(release)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		if panicCount != n {
+			t.Errorf("expected all callers to panic, got %d of %d", panicCount, n)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Do hangs")
+	}
+}
+
+func TestPanicErrorUnwrap(t *testing.T) {
+	var g Group
+	wantErr := errors.New("boom")
+	defer func() {
+		r := recover()
+		err, ok := r.(error)
+		if !ok || !errors.Is(err, wantErr) {
+			t.Errorf("recovered %v; want a panic error wrapping %v", r, wantErr)
+		}
+	}()
+	g.Do("key", func() (interface{}, error) {
+		panic(wantErr)
+	})
+}
+
+func TestGoexitDo(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-release
+		runtime.Goexit()
+		return nil, nil
+	}
+
+	const n = 5
+	returned := int32(0)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Do("key", fn)
+			atomic.AddInt32(&returned, 1)
+		}()
+	}
+	waitCallers(&g, "key", n)
+	ch := g.DoChan("key", fn)
+	close(release)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		if returned != 0 {
+			t.Errorf("expected every caller to exit, %d returned", returned)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Do hangs")
+	}
+	if res := <-ch; res.Err != errGoexit {
+		t.Errorf("DoChan error = %v; want errGoexit", res.Err)
+	}
+}
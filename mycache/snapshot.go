@@ -0,0 +1,211 @@
+package mycache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"time"
+)
+
+// Snapshot file layout, all integers are varints:
+//
+//	magic "MCSN" | version | group name | entry count |
+//	entries: key | value | expire in unix nanoseconds, 0 never |
+//	CRC-32C of everything before it, 4 bytes big endian
+//
+// Entries are written from the least to the most recently used so that
+// restoring them in order rebuilds the LRU order.
+const (
+	snapshotMagic   = "MCSN"
+	snapshotVersion = 1
+	// maxSnapshotKey bounds key lengths read from a snapshot.
+	maxSnapshotKey = 1 << 16
+)
+
+// ErrCorruptSnapshot is returned by Restore when the snapshot fails to decode or its checksum does not match.
+var ErrCorruptSnapshot = errors.New("mycache: corrupt snapshot")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Snapshot writes the unexpired contents of the group's cache to w.
+func (g *Group) Snapshot(w io.Writer) error {
+	hot := g.mainCache.hottest(math.MaxInt)
+
+	bw := bufio.NewWriter(w)
+	crc := crc32.New(castagnoli)
+	sw := &snapshotWriter{w: io.MultiWriter(bw, crc)}
+	sw.bytes([]byte(snapshotMagic))
+	sw.uvarint(snapshotVersion)
+	sw.string(g.name)
+	sw.uvarint(uint64(len(hot)))
+	for i := len(hot) - 1; i >= 0; i-- {
+		v, err := hot[i].value.decompress()
+		if err != nil {
+			return err
+		}
+		sw.string(hot[i].key)
+		sw.view(v)
+		sw.varint(unixNano(v.e))
+	}
+	if sw.err != nil {
+		return sw.err
+	}
+	if err := binary.Write(bw, binary.BigEndian, crc.Sum32()); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Restore reads a snapshot written by Snapshot of a group with the same
+// name and adds its unexpired entries to the cache. Nothing is added unless
+// the whole snapshot decodes and its checksum matches.
+func (g *Group) Restore(r io.Reader) error {
+	crc := crc32.New(castagnoli)
+	sr := &snapshotReader{r: bufio.NewReader(r), crc: crc}
+
+	if magic := sr.bytes(len(snapshotMagic)); sr.err == nil && string(magic) != snapshotMagic {
+		return fmt.Errorf("%w: bad magic %q", ErrCorruptSnapshot, magic)
+	}
+	if version := sr.uvarint(); sr.err == nil && version != snapshotVersion {
+		return fmt.Errorf("mycache: unsupported snapshot version %d", version)
+	}
+	if name := sr.string(maxSnapshotKey); sr.err == nil && name != g.name {
+		return fmt.Errorf("mycache: snapshot of group %s restored into %s", name, g.name)
+	}
+	n := sr.uvarint()
+	var entries []entry
+	for i := uint64(0); i < n && sr.err == nil; i++ {
+		key := sr.string(maxSnapshotKey)
+		value := sr.bytes(int(sr.uvarint()))
+		expire := sr.varint()
+		if sr.err != nil {
+			break
+		}
+		v := ByteView{b: value}
+		if expire != 0 {
+			v.e = time.Unix(0, expire)
+		}
+		entries = append(entries, entry{key, v})
+	}
+	if sr.err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptSnapshot, sr.err)
+	}
+	sum := crc.Sum32()
+	var want uint32
+	if err := binary.Read(sr.r, binary.BigEndian, &want); err != nil {
+		return fmt.Errorf("%w: reading checksum: %v", ErrCorruptSnapshot, err)
+	}
+	if sum != want {
+		return fmt.Errorf("%w: checksum mismatch", ErrCorruptSnapshot)
+	}
+
+	now := time.Now()
+	for _, e := range entries {
+		if !e.value.expired(now) {
+			g.populateCache(e.key, e.value)
+		}
+	}
+	return nil
+}
+
+// snapshotWriter writes snapshot fields, keeping the first error.
+type snapshotWriter struct {
+	w   io.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (w *snapshotWriter) bytes(b []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(b)
+	}
+}
+
+func (w *snapshotWriter) uvarint(v uint64) {
+	w.bytes(w.buf[:binary.PutUvarint(w.buf[:], v)])
+}
+
+func (w *snapshotWriter) varint(v int64) {
+	w.bytes(w.buf[:binary.PutVarint(w.buf[:], v)])
+}
+
+func (w *snapshotWriter) string(s string) {
+	w.uvarint(uint64(len(s)))
+	w.bytes([]byte(s))
+}
+
+// view writes v like a string, without copying it.
+func (w *snapshotWriter) view(v ByteView) {
+	w.uvarint(uint64(v.Len()))
+	if w.err == nil {
+		_, w.err = v.WriteTo(w.w)
+	}
+}
+
+// snapshotReader reads snapshot fields and feeds them to crc, keeping the first error.
+type snapshotReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	err error
+}
+
+func (r *snapshotReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (r *snapshotReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	var v uint64
+	v, r.err = binary.ReadUvarint(r)
+	return v
+}
+
+func (r *snapshotReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	var v int64
+	v, r.err = binary.ReadVarint(r)
+	return v
+}
+
+// bytes reads n bytes without trusting n for the allocation, so a corrupt
+// length fails at the end of the input instead of exhausting memory.
+func (r *snapshotReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 {
+		r.err = fmt.Errorf("negative length %d", n)
+		return nil
+	}
+	b, err := io.ReadAll(io.LimitReader(r.r, int64(n)))
+	if err == nil && len(b) < n {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		r.err = err
+		return nil
+	}
+	r.crc.Write(b)
+	return b
+}
+
+func (r *snapshotReader) string(max int) string {
+	n := r.uvarint()
+	if r.err == nil && n > uint64(max) {
+		r.err = fmt.Errorf("length %d exceeds %d", n, max)
+	}
+	return string(r.bytes(int(n)))
+}
@@ -0,0 +1,94 @@
+package mycache
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Stats are per-group statistics.
+type Stats struct {
+	Gets          AtomicInt // any Get request, including from peers
+	CacheHits     AtomicInt // the value was in the cache
+	StaleHits     AtomicInt // an expired value was served while it is refreshed
+	Refreshes     AtomicInt // background refreshes of stale or expiring values
+	RefreshErrs   AtomicInt // failed background refreshes
+	NegativeHits  AtomicInt // a cached not-found error was returned
+	Loads         AtomicInt // cache misses and background refreshes
+	LoadsDeduped  AtomicInt // after singleflight
+	PeerLoads     AtomicInt // either remote load or remote cache hit (not an error)
+	PeerErrors    AtomicInt
+	PeerRetries   AtomicInt // retries of peer loads that failed with a retryable error
+	Hedges        AtomicInt // slow peer loads hedged with a local load
+	HedgeWins     AtomicInt // hedged loads the local load answered first
+	LocalLoads    AtomicInt // total good local loads
+	LocalLoadErrs AtomicInt // total bad local loads
+	// FilterRejects counts keys the key filter rejected before loading them,
+	// FilterFalsePositives the keys it let through that turned out not to exist.
+	FilterRejects        AtomicInt
+	FilterFalsePositives AtomicInt
+	// CompressIn and CompressOut count the bytes given to and produced by
+	// the compressor, see CompressionRatio. DecompressErrs counts the
+	// evicted values dropped as they failed to decompress.
+	CompressIn     AtomicInt
+	CompressOut    AtomicInt
+	DecompressErrs AtomicInt
+	// ServerRejects counts get requests of peers and clients the HTTPPool
+	// rejected, see AdmissionOptions.
+	ServerRejects AtomicInt
+	// LoadQueueDepth is the number of loads waiting for the load limit,
+	// see SetLoadLimit. LoadQueueWaits counts the loads that waited,
+	// LoadQueueWaitTime their total wait in nanoseconds, and
+	// LoadQueueRejects those that failed as the queue was full or they
+	// timed out.
+	LoadQueueDepth    AtomicInt
+	LoadQueueWaits    AtomicInt
+	LoadQueueWaitTime AtomicInt
+	LoadQueueRejects  AtomicInt
+	// Leases counts the loads done under a load lease, see SetLoadLease,
+	// and LeaseWaits the values loaded by the holder of another lease.
+	Leases     AtomicInt
+	LeaseWaits AtomicInt
+}
+
+// CompressionRatio returns the size of the values the group compressed over
+// their compressed size, or 0 if it compressed none.
+func (s *Stats) CompressionRatio() float64 {
+	out := s.CompressOut.Get()
+	if out == 0 {
+		return 0
+	}
+	return float64(s.CompressIn.Get()) / float64(out)
+}
+
+// MeanLoadQueueWait returns the average wait of the loads that waited for
+// the load limit, or 0 if none did.
+func (s *Stats) MeanLoadQueueWait() time.Duration {
+	n := s.LoadQueueWaits.Get()
+	if n == 0 {
+		return 0
+	}
+	return time.Duration(s.LoadQueueWaitTime.Get() / n)
+}
+
+// An AtomicInt is an int64 to be accessed atomically.
+type AtomicInt int64
+
+// Add atomically adds n to i.
+func (i *AtomicInt) Add(n int64) {
+	atomic.AddInt64((*int64)(i), n)
+}
+
+// Get atomically gets the value of i.
+func (i *AtomicInt) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+func (i *AtomicInt) String() string {
+	return strconv.FormatInt(i.Get(), 10)
+}
+
+// MarshalJSON reads i atomically when encoding statistics.
+func (i *AtomicInt) MarshalJSON() ([]byte, error) {
+	return []byte(i.String()), nil
+}
@@ -0,0 +1,85 @@
+package mycache
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultMaxIdleConnsPerPeer = 64
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+	defaultDialTimeout         = 5 * time.Second
+)
+
+// TransportOptions tune the connections of a pool to its peers, see NewTransport.
+type TransportOptions struct {
+	// MaxIdleConnsPerPeer is the number of idle connections kept open to
+	// each peer, it defaults to 64.
+	MaxIdleConnsPerPeer int
+	// IdleConnTimeout is how long idle connections are kept open, it
+	// defaults to 90 seconds.
+	IdleConnTimeout time.Duration
+	// KeepAlive is the TCP keep-alive period, it defaults to 30 seconds.
+	KeepAlive time.Duration
+	// DialTimeout bounds connecting to a peer, it defaults to 5 seconds.
+	DialTimeout time.Duration
+	// ResponseHeaderTimeout bounds the wait for a peer's response once the
+	// request is sent, zero means no limit.
+	ResponseHeaderTimeout time.Duration
+	// H2C makes requests to http:// peers use HTTP/2 without TLS, so that
+	// concurrent requests to a peer share one connection. Every peer must
+	// accept it, as Server does. Requests over TLS negotiate HTTP/2 anyway.
+	H2C bool
+	// TLSClientConfig is the TLS configuration of requests to https://
+	// peers, e.g. with this node's client certificate for mutual TLS.
+	TLSClientConfig *tls.Config
+}
+
+// NewTransport returns a transport for requests to peers set up with o.
+// Unlike http.DefaultTransport it keeps enough idle connections to each
+// peer for a busy pool to reuse them instead of opening new ones.
+func NewTransport(o TransportOptions) *http.Transport {
+	if o.MaxIdleConnsPerPeer <= 0 {
+		o.MaxIdleConnsPerPeer = defaultMaxIdleConnsPerPeer
+	}
+	if o.IdleConnTimeout <= 0 {
+		o.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	if o.KeepAlive == 0 {
+		o.KeepAlive = defaultKeepAlive
+	}
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = defaultDialTimeout
+	}
+	dialer := &net.Dialer{Timeout: o.DialTimeout, KeepAlive: o.KeepAlive}
+	t := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConnsPerHost:   o.MaxIdleConnsPerPeer,
+		IdleConnTimeout:       o.IdleConnTimeout,
+		TLSHandshakeTimeout:   o.DialTimeout,
+		ResponseHeaderTimeout: o.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		TLSClientConfig:       o.TLSClientConfig,
+	}
+	if o.H2C {
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetHTTP2(true)
+		t.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return t
+}
+
+// serverProtocols are the protocols Server accepts from peers: HTTP/1,
+// and HTTP/2 both over TLS and without it.
+func serverProtocols() *http.Protocols {
+	p := new(http.Protocols)
+	p.SetHTTP1(true)
+	p.SetHTTP2(true)
+	p.SetUnencryptedHTTP2(true)
+	return p
+}
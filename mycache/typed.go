@@ -0,0 +1,156 @@
+package mycache
+
+import (
+	"fmt"
+	"mycache/lru"
+	"sync"
+)
+
+// A TypedGetter loads the value of type T for a key.
+type TypedGetter[T any] interface {
+	Get(key string) (T, error)
+}
+
+// A TypedGetterFunc implements TypedGetter with a function.
+type TypedGetterFunc[T any] func(key string) (T, error)
+
+func (f TypedGetterFunc[T]) Get(key string) (T, error) {
+	return f(key)
+}
+
+// CodecError is returned by TypedGroup when a value fails to encode or
+// decode, as opposed to failing to load.
+type CodecError struct {
+	// Op is "marshal" or "unmarshal".
+	Op    string
+	Group string
+	Key   string
+	Err   error
+}
+
+func (e *CodecError) Error() string {
+	return fmt.Sprintf("mycache: %s %s/%s: %v", e.Op, e.Group, e.Key, e.Err)
+}
+
+func (e *CodecError) Unwrap() error {
+	return e.Err
+}
+
+// TypedStats are statistics on the decoded values of a TypedGroup.
+type TypedStats struct {
+	DecodedHits AtomicInt // the decoded value was cached
+	Decodes     AtomicInt // values decoded from the group's bytes
+	CodecErrs   AtomicInt // values that failed to encode or decode
+}
+
+// TypedGroup is a Group of values of type T. Values are encoded with a Codec
+// for the group's cache and peers, and decoded values are cached too so
+// repeated Gets don't decode them again.
+type TypedGroup[T any] struct {
+	group *Group
+	codec Codec[T]
+
+	mu sync.Mutex
+	// decoded holds the decoded values and the views they were decoded from,
+	// sized by the encoded length. It is dropped once the group is deleted.
+	decoded *lru.TypedCache[string, decodedValue[T]]
+
+	// Stats are statistics on the decoded values.
+	Stats TypedStats
+}
+
+// decodedValue is a decoded value and the view it was decoded from.
+type decodedValue[T any] struct {
+	view  ByteView
+	value T
+}
+
+func (d decodedValue[T]) Len() int {
+	return d.view.Len()
+}
+
+// NewTypedGroup creates a TypedGroup and its underlying Group, configured by
+// opts. The size of the group's main cache, see WithCacheBytes, bounds the
+// encoded values and, separately, the decoded ones.
+func NewTypedGroup[T any](name string, getter TypedGetter[T], codec Codec[T], opts ...Option) (*TypedGroup[T], error) {
+	if getter == nil {
+		panic("nil getter")
+	}
+	t := &TypedGroup[T]{codec: codec}
+	opts = append(opts[:len(opts):len(opts)], func(g *Group) error {
+		g.onClose = append(g.onClose, t.release)
+		return nil
+	})
+	g, err := NewGroup(name, GetterFunc(func(key string) ([]byte, error) {
+		v, err := getter.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		b, err := codec.Marshal(v)
+		if err != nil {
+			t.Stats.CodecErrs.Add(1)
+			return nil, &CodecError{Op: "marshal", Group: name, Key: key, Err: err}
+		}
+		return b, nil
+	}), opts...)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	t.group = g
+	if !g.deleted() {
+		t.decoded = lru.NewTypedCache(g.mainCache.cacheBytes, entrySize[decodedValue[T]], nil)
+	}
+	t.mu.Unlock()
+	return t, nil
+}
+
+// release drops the decoded values, it is called when the group is deleted.
+func (t *TypedGroup[T]) release() {
+	t.mu.Lock()
+	t.decoded = nil
+	t.mu.Unlock()
+}
+
+// Group returns the underlying Group, to register peers or change its settings.
+func (t *TypedGroup[T]) Group() *Group {
+	return t.group
+}
+
+// Get returns the value for key. Values are shared between callers and must
+// not be modified.
+func (t *TypedGroup[T]) Get(key string) (T, error) {
+	var zero T
+	// the stored view, so that compressed values are only decompressed to be decoded
+	view, err := t.group.get(key)
+	if err != nil {
+		return zero, err
+	}
+
+	t.mu.Lock()
+	if t.decoded != nil {
+		if v, ok := t.decoded.Get(key); ok && v.view.same(view) {
+			t.mu.Unlock()
+			t.Stats.DecodedHits.Add(1)
+			return v.value, nil
+		}
+	}
+	t.mu.Unlock()
+
+	t.Stats.Decodes.Add(1)
+	raw, err := view.decompress()
+	if err != nil {
+		return zero, err
+	}
+	value, err := t.codec.Unmarshal(raw.data())
+	if err != nil {
+		t.Stats.CodecErrs.Add(1)
+		return zero, &CodecError{Op: "unmarshal", Group: t.group.name, Key: key, Err: err}
+	}
+	t.mu.Lock()
+	if t.decoded != nil {
+		t.decoded.Add(key, decodedValue[T]{view, value})
+	}
+	t.mu.Unlock()
+	return value, nil
+}